@@ -0,0 +1,65 @@
+package gtipc
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ParseClientAddr parses the address of a client as passed when opening a session. It is an IPv4 or IPv6 address
+// followed by a port, such as 203.0.113.7:19132 or [2001:db8::1]:19132, or an address without a port. IPv6
+// addresses may have a zone, such as [fe80::1%eth0]:19132. IPv4-mapped IPv6 addresses are returned as IPv4
+// addresses.
+func ParseClientAddr(s string) (netip.AddrPort, error) {
+	addrPort, err := netip.ParseAddrPort(s)
+	if err != nil {
+		addr, addrErr := parseIP(s)
+		if addrErr != nil {
+			return netip.AddrPort{}, fmt.Errorf("invalid client address %q: %w", s, err)
+		}
+		return netip.AddrPortFrom(addr, 0), nil
+	}
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port()), nil
+}
+
+// parseIP parses an IPv4 or IPv6 address, which may be enclosed in brackets and may have a zone. IPv4-mapped IPv6
+// addresses are returned as IPv4 addresses.
+func parseIP(s string) (netip.Addr, error) {
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		s = s[1 : len(s)-1]
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
+
+// blockKey returns the key an address is blocked by. The zone is dropped and IPv4-mapped IPv6 addresses are
+// unmapped, so that an address is blocked the same way however it is written. False is returned if the IP is
+// invalid.
+func blockKey(ip net.IP) (netip.Addr, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// sessionIDAddr returns the address sent to servers for a session without a client address, as they need one for
+// every session. It is made up from the low 24 bits of the session ID in 0.0.0.0/8, which no client connects from.
+// The address is not exposed otherwise.
+func sessionIDAddr(sessionID int32) netip.AddrPort {
+	return netip.AddrPortFrom(netip.AddrFrom4([4]byte{0, byte(sessionID >> 16), byte(sessionID >> 8), byte(sessionID)}), 0)
+}
+
+// isSessionIDAddr reports if an address is in the range of the addresses returned by sessionIDAddr.
+func isSessionIDAddr(addr netip.Addr) bool {
+	return addr.Is4() && addr.As4()[0] == 0
+}
+
+// udpAddr returns the address as a *net.UDPAddr, keeping its zone.
+func udpAddr(addrPort netip.AddrPort) *net.UDPAddr {
+	return &net.UDPAddr{IP: addrPort.Addr().AsSlice(), Port: int(addrPort.Port()), Zone: addrPort.Addr().Zone()}
+}
@@ -0,0 +1,257 @@
+package gtipc
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCustomPacketSize is the largest custom packet body accepted by the admin handler.
+const maxCustomPacketSize = 1 << 20
+
+// AdminHandler is an http.Handler serving a JSON API to inspect and control an IpcServer at runtime. It has no
+// authentication of its own, so it should only be served on a trusted address or behind a handler that checks
+// credentials. To serve it under a path prefix, wrap it in http.StripPrefix.
+//
+//	GET    /backends                            lists connected backends with their pong data and session count
+//	GET    /backends/{key}/sessions             lists the sessions of a backend
+//	POST   /backends/{key}/sessions/{id}/close  closes a session: {"reason": 1}
+//	POST   /backends/{key}/custom               sends the request body to a backend as a custom packet
+//	POST   /backends/{key}/drain                drains a backend: {"timeout": "30s"}
+//	DELETE /backends/{key}/drain                cancels the drain of a backend
+//	POST   /block                               blocks an address: {"address": "203.0.113.7", "duration": "1h"}
+//	POST   /unblock                             unblocks an address: {"address": "203.0.113.7"}
+type AdminHandler struct {
+	server *IpcServer
+	mux    *http.ServeMux
+}
+
+// AdminBackend is a backend listed by the admin handler.
+type AdminBackend struct {
+	Key string `json:"key"`
+	// Protocol is the version of the protocol layout used with the backend
+	Protocol uint8 `json:"protocol"`
+	// Pong is the parsed pong data of the backend, or nil if it has not sent valid pong data
+	Pong     *Pong `json:"pong"`
+	Sessions int   `json:"sessions"`
+	Draining bool  `json:"draining"`
+}
+
+// AdminSession is a session listed by the admin handler.
+type AdminSession struct {
+	ID int32 `json:"id"`
+	// ClientAddr is the address of the client as passed when the session was opened
+	ClientAddr string    `json:"client_addr"`
+	ClientID   int64     `json:"client_id"`
+	Opened     time.Time `json:"opened"`
+	// Age is the number of seconds since the session was opened
+	Age float64 `json:"age"`
+}
+
+// NewAdminHandler returns an AdminHandler for the server.
+func NewAdminHandler(server *IpcServer) *AdminHandler {
+	a := &AdminHandler{server: server, mux: http.NewServeMux()}
+	a.mux.HandleFunc("GET /backends", a.handleBackends)
+	a.mux.HandleFunc("GET /backends/{key}/sessions", a.handleSessions)
+	a.mux.HandleFunc("POST /backends/{key}/sessions/{id}/close", a.handleCloseSession)
+	a.mux.HandleFunc("POST /backends/{key}/custom", a.handleCustomPacket)
+	a.mux.HandleFunc("POST /backends/{key}/drain", a.handleDrain)
+	a.mux.HandleFunc("DELETE /backends/{key}/drain", a.handleCancelDrain)
+	a.mux.HandleFunc("POST /block", a.handleBlock)
+	a.mux.HandleFunc("POST /unblock", a.handleUnblock)
+	return a
+}
+
+// ServeHTTP ...
+func (a *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *AdminHandler) handleBackends(w http.ResponseWriter, _ *http.Request) {
+	a.server.connsMu.RLock()
+	conns := make([]*Conn, 0, len(a.server.ipcRaknetConns))
+	for _, conn := range a.server.ipcRaknetConns {
+		conns = append(conns, conn)
+	}
+	a.server.connsMu.RUnlock()
+	slices.SortFunc(conns, func(a, b *Conn) int { return strings.Compare(a.key, b.key) })
+
+	backends := make([]AdminBackend, 0, len(conns))
+	for _, conn := range conns {
+		pong, _ := conn.pong()
+		conn.sessionsMut.Lock()
+		sessions, draining := len(conn.sessions), conn.drain != nil
+		conn.sessionsMut.Unlock()
+		backends = append(backends, AdminBackend{Key: conn.key, Protocol: conn.protocol.Version(), Pong: pong, Sessions: sessions, Draining: draining})
+	}
+	writeAdminJSON(w, http.StatusOK, backends)
+}
+
+func (a *AdminHandler) handleSessions(w http.ResponseWriter, r *http.Request) {
+	conn, ok := a.server.GetConn(r.PathValue("key"))
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	now := time.Now()
+	sessions := make([]AdminSession, 0)
+	for _, s := range conn.Sessions() {
+		sessions = append(sessions, AdminSession{ID: s.ID(), ClientAddr: s.ClientAddr(), ClientID: s.ClientID(), Opened: s.Opened(), Age: now.Sub(s.Opened()).Seconds()})
+	}
+	writeAdminJSON(w, http.StatusOK, sessions)
+}
+
+func (a *AdminHandler) handleCloseSession(w http.ResponseWriter, r *http.Request) {
+	conn, ok := a.server.GetConn(r.PathValue("key"))
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	var req struct {
+		Reason byte `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAdminError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	session, ok := conn.Session(int32(id))
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, errors.New("session not found"))
+		return
+	}
+	if err := session.CloseWithReason(req.Reason); err != nil {
+		writeAdminError(w, http.StatusBadGateway, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminHandler) handleCustomPacket(w http.ResponseWriter, r *http.Request) {
+	conn, ok := a.server.GetConn(r.PathValue("key"))
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, errNotFound)
+		return
+	}
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCustomPacketSize))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := conn.WriteCustomPacket(b); err != nil {
+		writeAdminError(w, http.StatusBadGateway, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminHandler) handleDrain(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Timeout string `json:"timeout"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return
+	}
+	timeout, err := time.ParseDuration(req.Timeout)
+	if err != nil || timeout < 0 {
+		writeAdminError(w, http.StatusBadRequest, errors.New("timeout must be a duration such as 30s"))
+		return
+	}
+	switch err := a.server.Drain(r.PathValue("key"), timeout); {
+	case errors.Is(err, errNotFound):
+		writeAdminError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrDraining):
+		writeAdminError(w, http.StatusConflict, err)
+	case err != nil:
+		writeAdminError(w, http.StatusInternalServerError, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *AdminHandler) handleCancelDrain(w http.ResponseWriter, r *http.Request) {
+	switch err := a.server.CancelDrain(r.PathValue("key")); {
+	case errors.Is(err, errNotFound):
+		writeAdminError(w, http.StatusNotFound, err)
+	case errors.Is(err, errNotDraining):
+		writeAdminError(w, http.StatusConflict, err)
+	case err != nil:
+		writeAdminError(w, http.StatusInternalServerError, err)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (a *AdminHandler) handleBlock(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Address  string `json:"address"`
+		Duration string `json:"duration"`
+	}
+	ip, ok := a.decodeAddress(w, r, &req, func() string { return req.Address })
+	if !ok {
+		return
+	}
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil || d <= 0 {
+		writeAdminError(w, http.StatusBadRequest, errors.New("duration must be a positive duration such as 10m"))
+		return
+	}
+	a.server.BlockAddress(ip, d)
+	a.server.opts.Log.Info("Address blocked through admin handler", "addr", ip.String(), "duration", d.String())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *AdminHandler) handleUnblock(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Address string `json:"address"`
+	}
+	ip, ok := a.decodeAddress(w, r, &req, func() string { return req.Address })
+	if !ok {
+		return
+	}
+	a.server.UnblockAddress(ip)
+	a.server.opts.Log.Info("Address unblocked through admin handler", "addr", ip.String())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeAddress decodes a block or unblock request into req and parses the address in it. An error is written and
+// false returned if the request is invalid or no upstream handler is set to block addresses with.
+func (a *AdminHandler) decodeAddress(w http.ResponseWriter, r *http.Request, req any, addr func() string) (net.IP, bool) {
+	if a.server.opts.Upstream == nil {
+		writeAdminError(w, http.StatusNotImplemented, errors.New("no upstream handler is set"))
+		return nil, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	ip, err := parseIP(addr())
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, errors.New("address is not an IP address"))
+		return nil, false
+	}
+	return net.IP(ip.AsSlice()), true
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, err error) {
+	writeAdminJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,101 @@
+// Package capture implements recording IPC traffic to capture files and replaying it.
+package capture
+
+import (
+	"time"
+
+	"github.com/gameparrot/gtipc/ipcprotocol"
+	"github.com/gameparrot/gtipc/ipcprotocol/rak2user"
+	"github.com/gameparrot/gtipc/ipcprotocol/user2rak"
+	"github.com/gameparrot/gtipc/ipcprotocol/versions"
+)
+
+// Direction is the direction an IPC frame was sent in.
+type Direction byte
+
+const (
+	// DirectionServerToProxy is used for frames sent by the PM server, which hold user2rak packets
+	DirectionServerToProxy Direction = iota
+	// DirectionProxyToServer is used for frames sent to the PM server, which hold rak2user packets
+	DirectionProxyToServer
+)
+
+// String ...
+func (d Direction) String() string {
+	switch d {
+	case DirectionServerToProxy:
+		return "server->proxy"
+	case DirectionProxyToServer:
+		return "proxy->server"
+	}
+	return "unknown"
+}
+
+// Record is a single IPC frame in a capture.
+type Record struct {
+	// Direction is the direction the frame was sent in
+	Direction Direction
+	// Time is the time the frame was sent or received
+	Time time.Time
+	// ServerKey is the key of the server the frame was sent to or received from
+	ServerKey string
+	// SessionID is the session the packet in the frame belongs to. It is -1 for custom packets and 0 for packets
+	// that do not belong to a session
+	SessionID int32
+	// Frame holds the packet ID followed by the payload, without the length prefix
+	Frame []byte
+	// Protocol is the layout the frame is encoded with. versions.V1 is assumed if it is nil
+	Protocol *ipcprotocol.Protocol
+}
+
+// Packet decodes the packet in the frame of the record with the pools of its layout.
+func (r Record) Packet() (ipcprotocol.Packet, error) {
+	p := r.Protocol
+	if p == nil {
+		p = versions.V1
+	}
+	if r.Direction == DirectionProxyToServer {
+		return ipcprotocol.Decode(p.Rak2UserPool(), r.Frame)
+	}
+	return ipcprotocol.Decode(p.User2RakPool(), r.Frame)
+}
+
+// NewRecord returns a record for a frame encoded with the layout passed that was sent or received now, filling in
+// the session ID from the packet in it.
+func NewRecord(p *ipcprotocol.Protocol, dir Direction, serverKey string, frame []byte) Record {
+	r := Record{Direction: dir, Time: time.Now(), ServerKey: serverKey, Frame: frame, Protocol: p}
+	if pk, err := r.Packet(); err == nil {
+		r.SessionID = SessionID(pk)
+	}
+	return r
+}
+
+// SessionID returns the session ID of a packet, or 0 if the packet does not belong to a session.
+func SessionID(pk ipcprotocol.Packet) int32 {
+	switch pk := pk.(type) {
+	case *rak2user.Encapsulated:
+		return pk.SessionID
+	case *rak2user.OpenSession:
+		return pk.SessionID
+	case *rak2user.CloseSession:
+		return pk.SessionID
+	case *rak2user.AckNotification:
+		return pk.SessionID
+	case *rak2user.Raw:
+		return pk.SessionID
+	case *rak2user.ReportPing:
+		return pk.SessionID
+	case *user2rak.Encapsulated:
+		return pk.SessionID
+	case *user2rak.CloseSession:
+		return pk.SessionID
+	}
+	return 0
+}
+
+// magic is written at the start of every capture file, followed by the format version. Since format version 2, every
+// record holds the version of the protocol layout of its frame, so that a capture may hold the traffic of servers
+// using different layouts.
+var magic = []byte("GTIPCAP")
+
+const version = 2
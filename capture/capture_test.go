@@ -0,0 +1,206 @@
+package capture_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gameparrot/gtipc"
+	"github.com/gameparrot/gtipc/capture"
+	"github.com/gameparrot/gtipc/gtipctest"
+	"github.com/gameparrot/gtipc/ipcprotocol"
+	"github.com/gameparrot/gtipc/ipcprotocol/rak2user"
+	"github.com/gameparrot/gtipc/ipcprotocol/user2rak"
+	"github.com/gameparrot/gtipc/ipcprotocol/versions"
+)
+
+// shuffled is a layout that gives the packets of V1 other IDs, registered so that fake servers can select it.
+var shuffled = ipcprotocol.NewProtocol(200, map[uint8]func() ipcprotocol.Packet{
+	1: func() ipcprotocol.Packet { return &user2rak.SetName{} },
+	2: func() ipcprotocol.Packet { return &user2rak.Encapsulated{} },
+	3: func() ipcprotocol.Packet { return &user2rak.CloseSession{} },
+	4: func() ipcprotocol.Packet { return &user2rak.Raw{} },
+	5: func() ipcprotocol.Packet { return &user2rak.BlockAddress{} },
+	6: func() ipcprotocol.Packet { return &user2rak.UnblockAddress{} },
+	7: func() ipcprotocol.Packet { return &user2rak.RawFilter{} },
+}, map[uint8]func() ipcprotocol.Packet{
+	1: func() ipcprotocol.Packet { return &rak2user.OpenSession{} },
+	2: func() ipcprotocol.Packet { return &rak2user.Encapsulated{} },
+	3: func() ipcprotocol.Packet { return &rak2user.CloseSession{} },
+	4: func() ipcprotocol.Packet { return &rak2user.AckNotification{} },
+	5: func() ipcprotocol.Packet { return &rak2user.Raw{} },
+	6: func() ipcprotocol.Packet { return &rak2user.ReportPing{} },
+	7: func() ipcprotocol.Packet { return &rak2user.ReportBandwidthStats{} },
+})
+
+func init() {
+	versions.Register(shuffled)
+}
+
+// TestObserverLayouts records an IpcServer with servers on different layouts into a single capture.
+func TestObserverLayouts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	buf := new(bytes.Buffer)
+	w, err := capture.NewWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "gtipc.sock")
+	s, err := gtipc.NewIPCServer(sock, &gtipc.IpcOptions{FrameObserver: w.Observer()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	layouts := map[string]*ipcprotocol.Protocol{"v1": versions.V1, "shuffled": shuffled}
+	for key, p := range layouts {
+		fake, err := gtipctest.ConnectProtocol(sock, key, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		must(t, fake.SetPong([]byte("MCPE;"+key+";712;1.21.20;0;20;1;")))
+		var conn *gtipc.Conn
+		for conn == nil || conn.PongData() == nil {
+			if ctx.Err() != nil {
+				t.Fatalf("server %s did not connect or send its pong", key)
+			}
+			conn, _ = s.GetConn(key)
+			time.Sleep(time.Millisecond)
+		}
+		session, err := conn.OpenSession("203.0.113.7:19132")
+		must(t, err)
+		fs, err := fake.Accept(ctx)
+		must(t, err)
+		_, err = fs.Write([]byte{0xfe, 0x01})
+		must(t, err)
+		_, err = session.(gtipc.Session).ReadPacket()
+		must(t, err)
+		must(t, session.Close())
+		waitDone(t, ctx, fs.Done())
+		must(t, fake.Close())
+		waitDone(t, ctx, conn.Done())
+	}
+	if err := w.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := capture.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]int)
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := layouts[rec.ServerKey]; rec.Protocol != want {
+			t.Fatalf("record of %s has protocol version %d, want %d", rec.ServerKey, rec.Protocol.Version(), want.Version())
+		}
+		pk, err := rec.Packet()
+		if err != nil {
+			t.Fatalf("decode %v frame %x of %s: %v", rec.Direction, rec.Frame, rec.ServerKey, err)
+		}
+		if frame, _ := rec.Protocol.Encode(pk); !bytes.Equal(frame, rec.Frame) {
+			t.Fatalf("%T encodes to %x, recorded as %x", pk, frame, rec.Frame)
+		}
+		seen[rec.ServerKey]++
+	}
+	// SetName, OpenSession, the payload of the server and CloseSession.
+	for key := range layouts {
+		if seen[key] != 4 {
+			t.Errorf("capture has %d records of %s, want 4", seen[key], key)
+		}
+	}
+}
+
+func TestReaderFrameSize(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w, err := capture.NewWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Frames larger than the read buffer of a conn are accepted by it, so captures must hold them too.
+	frame, err := versions.V1.Encode(&user2rak.Encapsulated{SessionID: 1, UserPayload: bytes.Repeat([]byte{0xfe}, 1<<20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	must(t, w.Write(capture.NewRecord(versions.V1, capture.DirectionServerToProxy, "lobby", frame)))
+	r, err := capture.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rec.Frame, frame) || rec.SessionID != 1 {
+		t.Fatalf("read record of session %d with %d byte frame, want session 1 with %d bytes", rec.SessionID, len(rec.Frame), len(frame))
+	}
+
+	// A record claiming a frame far larger than the data left fails without allocating the claimed size.
+	b := append([]byte("GTIPCAP"), 2, byte(capture.DirectionServerToProxy), 1, 0, 5)
+	b = append(b, "lobby"...)
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.AppendUvarint(b, 1<<31)
+	b = append(b, 0x01, 0x02)
+	r, err = capture.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("reading truncated frame returned %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestInterceptorRecordError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	dir := t.TempDir()
+	target := filepath.Join(dir, "gtipc.sock")
+	s, err := gtipc.NewIPCServer(target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	errFull := errors.New("disk full")
+	i := &capture.Interceptor{
+		ListenPath: filepath.Join(dir, "intercept.sock"),
+		TargetPath: target,
+		OnRecord:   func(capture.Record) error { return errFull },
+	}
+	done := make(chan error, 1)
+	go func() { done <- i.ListenAndServe(ctx) }()
+
+	var fake *gtipctest.Server
+	for fake == nil {
+		if ctx.Err() != nil {
+			t.Fatal("interceptor did not start listening")
+		}
+		fake, _ = gtipctest.Connect(i.ListenPath, "lobby")
+		time.Sleep(time.Millisecond)
+	}
+	defer fake.Close()
+	must(t, fake.SetPong([]byte("MCPE;Lobby;712;1.21.20;0;20;1;")))
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, errFull) {
+			t.Fatalf("ListenAndServe returned %v, want the error of OnRecord", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("interceptor did not stop after OnRecord failed")
+	}
+}
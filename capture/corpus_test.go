@@ -0,0 +1,230 @@
+package capture_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gameparrot/gtipc"
+	"github.com/gameparrot/gtipc/capture"
+	"github.com/gameparrot/gtipc/gtipctest"
+	"github.com/gameparrot/gtipc/ipcprotocol"
+	"github.com/gameparrot/gtipc/ipcprotocol/rak2user"
+	"github.com/gameparrot/gtipc/ipcprotocol/user2rak"
+	"github.com/gameparrot/gtipc/ipcprotocol/versions"
+)
+
+var updateCorpus = flag.Bool("update-corpus", false, "write the synthetic traffic to the seed corpora of the fuzz tests")
+
+// TestSyntheticTraffic records a scripted exchange between an IpcServer and a gtipctest fake PM server for every
+// layout and checks that every recorded frame decodes and encodes to the same frame with the layout of its record.
+// With -update-corpus, the frames of V1 are written to the seed corpora of FuzzDecode, FuzzTakePackets and
+// FuzzReadPacket as the synthetic-* seeds. They are synthetic: no traffic of a real PM server is
+// checked in.
+func TestSyntheticTraffic(t *testing.T) {
+	for _, p := range []*ipcprotocol.Protocol{versions.V1, shuffled} {
+		t.Run(fmt.Sprintf("v%d", p.Version()), func(t *testing.T) {
+			records := record(t, p)
+
+			var fromServer, toServer []byte
+			for _, r := range records {
+				if r.Protocol != p {
+					t.Fatalf("record has protocol version %d, want %d", r.Protocol.Version(), p.Version())
+				}
+				pk, err := r.Packet()
+				if err != nil {
+					t.Fatalf("decode %v frame %x: %v", r.Direction, r.Frame, err)
+				}
+				frame, err := r.Protocol.Encode(pk)
+				if err != nil {
+					t.Fatalf("encode %T: %v", pk, err)
+				}
+				if !bytes.Equal(frame, r.Frame) {
+					t.Fatalf("%T encodes to %x, recorded as %x", pk, frame, r.Frame)
+				}
+				if r.Direction == capture.DirectionServerToProxy {
+					fromServer = appendFrame(fromServer, r.Frame)
+				} else {
+					toServer = appendFrame(toServer, r.Frame)
+				}
+			}
+			if !*updateCorpus || p != versions.V1 {
+				return
+			}
+
+			for i, r := range records {
+				writeCorpusFile(t, "../ipcprotocol/testdata/fuzz/FuzzDecode", fmt.Sprintf("synthetic-v%d-%02d", p.Version(), i), r.Direction == capture.DirectionServerToProxy, r.Frame)
+			}
+			writeCorpusFile(t, "../testdata/fuzz/FuzzReadPacket", "synthetic", fromServer)
+			writeCorpusFile(t, "../testdata/fuzz/FuzzTakePackets", "synthetic-server", fromServer, []byte{1, 7, 64, 255})
+			writeCorpusFile(t, "../testdata/fuzz/FuzzTakePackets", "synthetic-proxy", toServer, []byte{3, 200})
+		})
+	}
+}
+
+// record runs the scripted exchange with a fake server speaking the layout passed and returns the records captured
+// by the IpcServer.
+func record(t *testing.T, p *ipcprotocol.Protocol) []capture.Record {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	buf := new(bytes.Buffer)
+	w, err := capture.NewWriter(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "gtipc.sock")
+	s, err := gtipc.NewIPCServer(sock, &gtipc.IpcOptions{FrameObserver: w.Observer()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	fake, err := gtipctest.ConnectProtocol(sock, "lobby", p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	must(t, fake.SetPong([]byte("MCPE;Lobby;712;1.21.20;3;20;1;gtipc;Survival;1;19132;19133;")))
+
+	// The conn must be connected and have handled the pong before sessions are dialed.
+	var conn *gtipc.Conn
+	for conn == nil || conn.PongData() == nil {
+		if ctx.Err() != nil {
+			t.Fatal("server did not connect or send its pong")
+		}
+		conn, _ = s.GetConn("lobby")
+		time.Sleep(time.Millisecond)
+	}
+
+	// A session with metadata, payloads both ways, an ack, a ping and a close by the proxy.
+	session, err := s.DialSession(ctx, "lobby", gtipc.SessionOptions{ClientAddr: "203.0.113.7:19132", ClientID: 1234567890123, XUID: "2535412345678901"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := fake.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Metadata(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, err = session.(io.Writer).Write([]byte{0xfe, 0xc1, 0x01, 0x00})
+	must(t, err)
+	_, err = fs.ReadPacket()
+	must(t, err)
+	_, err = fs.Write([]byte{0xfe, 0x01, 0x02, 0x03})
+	must(t, err)
+	_, err = session.ReadPacket()
+	must(t, err)
+	must(t, fs.WriteWithAck([]byte{0xfe, 0x04}, 1))
+	_, err = session.ReadPacket()
+	must(t, err)
+	for len(fs.Acks()) == 0 {
+		if ctx.Err() != nil {
+			t.Fatal("ack was not received")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	must(t, conn.SendPing(session.ID(), 42*time.Millisecond))
+	_, err = fake.WaitPing(ctx, session.ID())
+	must(t, err)
+	if _, ok := p.ID(&rak2user.ReportBandwidthStats{}); ok {
+		must(t, conn.ReportBandwidth(1<<20, 1<<16))
+		_, err = fake.WaitBandwidthReport(ctx, 1)
+		must(t, err)
+	}
+
+	// Packets of the server that are not tied to a session.
+	must(t, fake.SendCustomPacket([]byte("custom")))
+	must(t, fake.BlockAddress("198.51.100.1", time.Minute))
+	must(t, fake.UnblockAddress("198.51.100.1"))
+	must(t, fake.WritePacket(&user2rak.RawFilter{Filter: "\xfe"}))
+	must(t, fake.WritePacket(&user2rak.Raw{Addr: "198.51.100.1", Port: 19132, Payload: []byte{0x01, 0x02}}))
+
+	// A session of an IPv6 client closed by the server.
+	v6, err := s.DialSession(ctx, "lobby", gtipc.SessionOptions{ClientAddr: "[2001:db8::1]:19132", ClientID: 1234567890124})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs6, err := fake.Accept(ctx)
+	must(t, err)
+	must(t, fs6.Close())
+	waitDone(t, ctx, v6.Done())
+
+	must(t, session.CloseWithReason(rak2user.DisconnectReasonClientDisconnect))
+	waitDone(t, ctx, fs.Done())
+
+	// Every frame of the server is observed before the conn is closed once the connection is.
+	must(t, fake.Close())
+	waitDone(t, ctx, conn.Done())
+	if err := w.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := capture.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []capture.Record
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+}
+
+// writeCorpusFile writes a file with the values passed to the corpus directory of a fuzz test, in the format of
+// go test fuzz v1.
+func writeCorpusFile(t *testing.T, dir, name string, values ...any) {
+	b := []byte("go test fuzz v1\n")
+	for _, v := range values {
+		switch v := v.(type) {
+		case bool:
+			b = fmt.Appendf(b, "bool(%t)\n", v)
+		case []byte:
+			b = fmt.Appendf(b, "[]byte(%q)\n", v)
+		default:
+			t.Fatalf("unsupported corpus value %T", v)
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// appendFrame appends a frame to b, prefixed with its length.
+func appendFrame(b, frame []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(b, uint32(len(frame))), frame...)
+}
+
+func waitDone(t *testing.T, ctx context.Context, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatal(ctx.Err())
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
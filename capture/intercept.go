@@ -0,0 +1,179 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gameparrot/gtipc/ipcprotocol"
+	"github.com/gameparrot/gtipc/ipcprotocol/versions"
+)
+
+// Interceptor forwards IPC traffic between a unix socket that it listens on and a target unix socket, passing every
+// forwarded frame to OnRecord.
+type Interceptor struct {
+	// ListenPath is the socket path the interceptor listens on
+	ListenPath string
+	// TargetPath is the socket path that accepted connections are forwarded to
+	TargetPath string
+	// ServerListens should be true if the PM server listens on TargetPath and the proxy connects to ListenPath, which
+	// is the case with an IpcClient. Otherwise the PM server is expected to connect to ListenPath and send its name
+	// first, as with an IpcServer.
+	ServerListens bool
+	// Protocol is the layout of the forwarded traffic, which the records are decoded with. PM servers that select a
+	// layout by appending a zero byte and its version to their name override it. versions.V1 is used if nil
+	Protocol *ipcprotocol.Protocol
+	// OnRecord is called with every forwarded frame before it is forwarded. It may be called from several goroutines
+	// at once. If it returns an error, the interceptor stops and ListenAndServe returns the error.
+	OnRecord func(r Record) error
+	// Log is the logger used for connection errors. slog.Default is used if nil.
+	Log *slog.Logger
+}
+
+// ListenAndServe listens on ListenPath and forwards accepted connections until ctx is done or OnRecord returns an
+// error, which is then returned.
+func (i *Interceptor) ListenAndServe(ctx context.Context) error {
+	if i.Log == nil {
+		i.Log = slog.Default()
+	}
+	if _, err := os.Stat(i.ListenPath); err == nil {
+		os.Remove(i.ListenPath)
+	}
+	listener, err := net.Listen("unix", i.ListenPath)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	stop := context.AfterFunc(ctx, func() {
+		listener.Close()
+	})
+	defer stop()
+	defer os.Remove(i.ListenPath)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			var recErr *recordError
+			if errors.As(context.Cause(ctx), &recErr) {
+				return recErr.err
+			}
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := i.forward(ctx, conn)
+			var recErr *recordError
+			if errors.As(err, &recErr) {
+				cancel(recErr)
+			} else if err != nil {
+				i.Log.Error("Failed to forward IPC conn", "err", err.Error())
+			}
+		}()
+	}
+}
+
+// recordError wraps an error returned by OnRecord, which stops the interceptor.
+type recordError struct {
+	err error
+}
+
+// Error ...
+func (e *recordError) Error() string {
+	return e.err.Error()
+}
+
+// forward forwards frames between an accepted conn and a new conn to the target until either side closes.
+func (i *Interceptor) forward(ctx context.Context, accepted net.Conn) error {
+	defer accepted.Close()
+
+	var (
+		key  = filepath.Clean(i.TargetPath)
+		name []byte
+		p    = i.Protocol
+	)
+	if p == nil {
+		p = versions.V1
+	}
+	if !i.ServerListens {
+		var nameLen [1]byte
+		if _, err := io.ReadFull(accepted, nameLen[:]); err != nil {
+			return err
+		}
+		name = make([]byte, nameLen[0])
+		if _, err := io.ReadFull(accepted, name); err != nil {
+			return err
+		}
+		key = string(name)
+		if n := bytes.IndexByte(name, 0); n != -1 && n == len(name)-2 {
+			key = string(name[:n])
+			if selected, ok := versions.Lookup(name[n+1]); ok {
+				p = selected
+			}
+		}
+	}
+
+	var d net.Dialer
+	target, err := d.DialContext(ctx, "unix", i.TargetPath)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+	if !i.ServerListens {
+		if _, err := target.Write(append([]byte{byte(len(name))}, name...)); err != nil {
+			return err
+		}
+	}
+
+	server, proxy := accepted, target
+	if i.ServerListens {
+		server, proxy = target, accepted
+	}
+	stop := context.AfterFunc(ctx, func() {
+		accepted.Close()
+		target.Close()
+	})
+	defer stop()
+
+	errs := make(chan error, 2)
+	go func() { errs <- i.copyFrames(proxy, server, p, DirectionServerToProxy, key) }()
+	go func() { errs <- i.copyFrames(server, proxy, p, DirectionProxyToServer, key) }()
+	err = <-errs
+	// Closing both conns makes the other copy return too.
+	accepted.Close()
+	target.Close()
+	<-errs
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+func (i *Interceptor) copyFrames(dst io.Writer, src io.Reader, p *ipcprotocol.Protocol, dir Direction, key string) error {
+	for {
+		frame, err := readFrame(src)
+		if err != nil {
+			return err
+		}
+		if i.OnRecord != nil {
+			if err := i.OnRecord(NewRecord(p, dir, key, frame)); err != nil {
+				return &recordError{err: err}
+			}
+		}
+		if err := writeFrame(dst, frame); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/gameparrot/gtipc/ipcprotocol/versions"
+)
+
+// Reader reads records from a capture.
+type Reader struct {
+	r       *bufio.Reader
+	last    time.Time
+	version byte
+}
+
+// NewReader returns a Reader that reads a capture from r. The capture header is read and validated immediately.
+// Captures of format version 1, which did not record protocol layouts, are read as holding frames of versions.V1.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, fmt.Errorf("read capture header: %w", err)
+	}
+	if !bytes.Equal(header[:len(magic)], magic) {
+		return nil, errors.New("not a gtipc capture")
+	}
+	v := header[len(magic)]
+	if v != 1 && v != version {
+		return nil, fmt.Errorf("unsupported capture version %d", v)
+	}
+	return &Reader{r: br, version: v}, nil
+}
+
+// Next reads the next record from the capture. It returns io.EOF once all records have been read.
+func (r *Reader) Next() (Record, error) {
+	rec := Record{Protocol: versions.V1}
+	dir, err := r.r.ReadByte()
+	if err != nil {
+		return rec, err
+	}
+	rec.Direction = Direction(dir)
+
+	if r.version != 1 {
+		v, err := r.r.ReadByte()
+		if err != nil {
+			return rec, r.unexpected(err)
+		}
+		p, ok := versions.Lookup(v)
+		if !ok {
+			return rec, fmt.Errorf("read record: unsupported protocol version %d", v)
+		}
+		rec.Protocol = p
+	}
+
+	delta, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return rec, r.unexpected(err)
+	}
+	if r.last.IsZero() {
+		r.last = time.Unix(0, int64(delta))
+	} else {
+		r.last = r.last.Add(time.Duration(delta))
+	}
+	rec.Time = r.last
+
+	keyLen, err := r.r.ReadByte()
+	if err != nil {
+		return rec, r.unexpected(err)
+	}
+	key := make([]byte, keyLen)
+	if _, err := io.ReadFull(r.r, key); err != nil {
+		return rec, r.unexpected(err)
+	}
+	rec.ServerKey = string(key)
+
+	var sid [4]byte
+	if _, err := io.ReadFull(r.r, sid[:]); err != nil {
+		return rec, r.unexpected(err)
+	}
+	rec.SessionID = int32(binary.BigEndian.Uint32(sid[:]))
+
+	frameLen, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return rec, r.unexpected(err)
+	}
+	if frameLen > math.MaxUint32 {
+		return rec, fmt.Errorf("read record: frame of %d bytes does not fit in an IPC frame", frameLen)
+	}
+	if rec.Frame, err = readN(r.r, frameLen); err != nil {
+		return rec, r.unexpected(err)
+	}
+	return rec, nil
+}
+
+// unexpected turns io.EOF in the middle of a record into io.ErrUnexpectedEOF.
+func (r *Reader) unexpected(err error) error {
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("read record: %w", io.ErrUnexpectedEOF)
+	}
+	return fmt.Errorf("read record: %w", err)
+}
+
+// readFrame reads a single length prefixed IPC frame from a stream. Like gtipc.Conn, it accepts frames of any size a
+// uint32 length prefix allows.
+func readFrame(r io.Reader) ([]byte, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	return readN(r, uint64(binary.BigEndian.Uint32(l[:])))
+}
+
+// readN reads exactly n bytes from r. The buffer grows as data is read rather than being allocated up front, so that
+// a corrupt length does not allocate more than the data actually present.
+func readN(r io.Reader, n uint64) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeFrame writes a single IPC frame to a stream, prefixed with its length.
+func writeFrame(w io.Writer, frame []byte) error {
+	b := binary.BigEndian.AppendUint32(make([]byte, 0, len(frame)+4), uint32(len(frame)))
+	_, err := w.Write(append(b, frame...))
+	return err
+}
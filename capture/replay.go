@@ -0,0 +1,107 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/gameparrot/gtipc/ipcprotocol"
+)
+
+// Replayer replays the frames of a capture that were sent in one direction.
+type Replayer struct {
+	// Direction is the direction of the frames to replay. Frames sent in the other direction are skipped
+	Direction Direction
+	// Speed is the factor that the original timing is sped up by. Frames are replayed without delay if Speed is zero
+	// or negative
+	Speed float64
+	// Dial opens the conn that frames for a server key are written to, passing the protocol layout of the first
+	// frame replayed for it. Anything read from the conn is discarded
+	Dial func(serverKey string, p *ipcprotocol.Protocol) (net.Conn, error)
+}
+
+// DialIpcServer returns a dial function that connects to an IpcServer on the socket path as a PM server would,
+// sending the server key as its name. It is used to replay frames sent by PM servers. The layout passed is selected
+// in the handshake by appending a zero byte and its version to the name, so that the IpcServer decodes the frames
+// with it.
+func DialIpcServer(socketPath string) func(serverKey string, p *ipcprotocol.Protocol) (net.Conn, error) {
+	return func(serverKey string, p *ipcprotocol.Protocol) (net.Conn, error) {
+		name := []byte(serverKey)
+		if p != nil {
+			name = append(name, 0, p.Version())
+		}
+		if len(name) > 255 {
+			return nil, fmt.Errorf("server key %q is too long", serverKey)
+		}
+		conn, err := net.Dial("unix", socketPath)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(append([]byte{byte(len(name))}, name...)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// DialPeer returns a dial function that connects to a PM server, or a fake one, listening on the socket path. It is
+// used to replay frames sent by the proxy, which the peer is expected to decode with its own layout.
+func DialPeer(socketPath string) func(serverKey string, p *ipcprotocol.Protocol) (net.Conn, error) {
+	return func(string, *ipcprotocol.Protocol) (net.Conn, error) {
+		return net.Dial("unix", socketPath)
+	}
+}
+
+// Replay replays all records read from r until the end of the capture is reached or ctx is done. Conns are dialed
+// when the first frame for their server key is replayed, and closed once Replay returns.
+func (p Replayer) Replay(ctx context.Context, r *Reader) error {
+	conns := make(map[string]net.Conn)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	var first time.Time
+	start := time.Now()
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if rec.Direction != p.Direction {
+			continue
+		}
+		if first.IsZero() {
+			first = rec.Time
+		}
+		if p.Speed > 0 {
+			at := start.Add(time.Duration(float64(rec.Time.Sub(first)) / p.Speed))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Until(at)):
+			}
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		conn, ok := conns[rec.ServerKey]
+		if !ok {
+			if conn, err = p.Dial(rec.ServerKey, rec.Protocol); err != nil {
+				return fmt.Errorf("dial %s: %w", rec.ServerKey, err)
+			}
+			conns[rec.ServerKey] = conn
+			go io.Copy(io.Discard, conn)
+		}
+		if err := writeFrame(conn, rec.Frame); err != nil {
+			return fmt.Errorf("replay frame to %s: %w", rec.ServerKey, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gameparrot/gtipc"
+	"github.com/gameparrot/gtipc/ipcprotocol"
+	"github.com/gameparrot/gtipc/ipcprotocol/versions"
+)
+
+// Writer writes records to a capture. It is safe for concurrent use.
+type Writer struct {
+	mu   sync.Mutex
+	w    io.Writer
+	last time.Time
+	buf  []byte
+	err  error
+}
+
+// NewWriter returns a Writer that writes a capture to w, starting with the capture header.
+func NewWriter(w io.Writer) (*Writer, error) {
+	if _, err := w.Write(append(bytes.Clone(magic), version)); err != nil {
+		return nil, fmt.Errorf("write capture header: %w", err)
+	}
+	return &Writer{w: w}, nil
+}
+
+// Write writes a record to the capture. Each record is encoded as the direction, the version of the protocol layout
+// of the frame, the time since the previous record in nanoseconds as a varuint64, the server key with a uint8 length
+// prefix, the session ID as a big endian int32 and the frame with a varuint32 length prefix. Records without a
+// layout are written as versions.V1.
+//
+// Once a record fails to be written, the capture is incomplete and Write returns the same error for all later
+// records.
+func (w *Writer) Write(r Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	if len(r.ServerKey) > 255 {
+		w.err = fmt.Errorf("server key %q is too long", r.ServerKey)
+		return w.err
+	}
+	p := r.Protocol
+	if p == nil {
+		p = versions.V1
+	}
+	var delta uint64
+	switch {
+	case w.last.IsZero():
+		// The first record holds the absolute time, so that captures can be correlated with logs.
+		delta = uint64(r.Time.UnixNano())
+		w.last = r.Time
+	case r.Time.After(w.last):
+		delta = uint64(r.Time.Sub(w.last))
+		w.last = r.Time
+	}
+
+	b := w.buf[:0]
+	b = append(b, byte(r.Direction), p.Version())
+	b = binary.AppendUvarint(b, delta)
+	b = append(b, byte(len(r.ServerKey)))
+	b = append(b, r.ServerKey...)
+	b = binary.BigEndian.AppendUint32(b, uint32(r.SessionID))
+	b = binary.AppendUvarint(b, uint64(len(r.Frame)))
+	b = append(b, r.Frame...)
+	w.buf = b
+
+	if _, err := w.w.Write(b); err != nil {
+		w.err = fmt.Errorf("write record: %w", err)
+		return w.err
+	}
+	return nil
+}
+
+// Err returns the error that writing a record to the capture failed with, or nil if all records were written. It is
+// used to check the records written by an Observer, which has no way to return errors.
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Observer returns a frame observer that writes every frame to the capture, along with the protocol layout of the
+// conn. It may be set as the FrameObserver of gtipc.IpcOptions to record all traffic of an IpcServer or IpcClient.
+// Writing stops at the first record that fails to be written, after which Err returns the error.
+func (w *Writer) Observer() gtipc.FrameObserver {
+	return func(serverKey string, p *ipcprotocol.Protocol, sent bool, frame []byte) {
+		dir := DirectionServerToProxy
+		if sent {
+			dir = DirectionProxyToServer
+		}
+		_ = w.Write(NewRecord(p, dir, serverKey, frame))
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gameparrot/gtipc/internal"
@@ -13,7 +14,8 @@ import (
 )
 
 type clientConn struct {
-	addr *ipcAddr
+	addr       *ipcAddr
+	remoteAddr *net.UDPAddr
 
 	ctx        context.Context
 	cancelFunc context.CancelFunc
@@ -21,21 +23,49 @@ type clientConn struct {
 	lastPacketTime time.Time
 	conn           *Conn
 	sessionId      int32
-
+	clientAddr     string
+	clientID       int64
+	metadata       map[string]string
+	opened         time.Time
+
+	// queueMu is held while payloads are added to userPackets, and while the session is closed and the payloads left
+	// in it are removed, so that MetricSessionQueueDepth counts only payloads that are in the queue.
+	queueMu     sync.Mutex
 	userPackets *internal.ElasticChan[[]byte]
 
+	span            Span
+	receivedPackets bool
+	acks            atomic.Int64
+
 	once sync.Once
 }
 
-func newClientConn(conn *Conn, sessionId int32) *clientConn {
+func newClientConn(conn *Conn, sessionId int32, clientAddr string, remoteAddr *net.UDPAddr, clientID int64, metadata map[string]string, span Span) *clientConn {
 	c, cancel := context.WithCancel(context.Background())
-	return &clientConn{conn: conn, sessionId: sessionId, ctx: c, cancelFunc: cancel, userPackets: internal.Chan[[]byte](4, 4096), addr: &ipcAddr{Key: conn.key, SessionId: sessionId}}
+	return &clientConn{conn: conn, sessionId: sessionId, clientAddr: clientAddr, remoteAddr: remoteAddr, clientID: clientID, metadata: metadata, opened: time.Now(), ctx: c, cancelFunc: cancel, userPackets: internal.Chan[[]byte](4, 4096), addr: &ipcAddr{Key: conn.key, SessionId: sessionId}, span: span}
 }
 
+// handlePacketFromServer is called by the read loop of the conn with a payload sent by the server for the session.
+// Payloads for a session that is being closed are dropped.
 func (c *clientConn) handlePacketFromServer(payload []byte, needsAck bool, ack int32) {
+	c.queueMu.Lock()
+	if c.ctx.Err() != nil {
+		c.queueMu.Unlock()
+		return
+	}
 	c.userPackets.Send(payload)
+	c.conn.metrics.AddGauge(MetricSessionQueueDepth, 1, "server", c.conn.key)
+	c.queueMu.Unlock()
+
+	if !c.receivedPackets {
+		c.receivedPackets = true
+		c.span.AddEvent(EventFirstServerPacket, Attr("size", len(payload)))
+	}
 	if needsAck {
 		c.conn.WritePacket(&rak2user.AckNotification{SessionID: c.sessionId, ACK: ack})
+		if c.acks.Add(1) == 1 {
+			c.span.AddEvent(EventAckNotification, Attr("ack", ack))
+		}
 	}
 }
 
@@ -52,6 +82,7 @@ func (c *clientConn) ReadPacket() ([]byte, error) {
 	if !ok {
 		return nil, net.ErrClosed
 	}
+	c.conn.metrics.AddGauge(MetricSessionQueueDepth, -1, "server", c.conn.key)
 	return pk, nil
 }
 
@@ -64,23 +95,52 @@ func (c *clientConn) Read(b []byte) (int, error) {
 }
 
 func (c *clientConn) Close() error {
-	c.internalClose()
+	return c.closeWithReason(rak2user.DisconnectReasonClientDisconnect)
+}
+
+// closeWithReason closes the session and tells the server why it was closed. Nothing is sent if the session was
+// already closed.
+func (c *clientConn) closeWithReason(reason byte) error {
+	if !c.internalClose(reason) {
+		return nil
+	}
 	c.conn.removeSession(c.sessionId)
-	return c.conn.WritePacket(&rak2user.CloseSession{SessionID: c.sessionId})
+	return c.conn.WritePacket(&rak2user.CloseSession{SessionID: c.sessionId, Reason: reason})
 }
 
+// LocalAddr returns the address of the session on the server, made up of the server key and the session ID.
 func (c *clientConn) LocalAddr() net.Addr {
 	return c.addr
 }
 
+// RemoteAddr returns the address of the client as sent to the server when the session was opened. If the session was
+// opened without a client address, the address of the session on the server is returned, as LocalAddr does.
 func (c *clientConn) RemoteAddr() net.Addr {
-	return c.addr
+	if c.remoteAddr == nil {
+		return c.addr
+	}
+	return c.remoteAddr
 }
 
-func (c *clientConn) internalClose() {
+// internalClose closes the session without telling the server. It reports if the session was closed by this call.
+func (c *clientConn) internalClose(reason byte) (closed bool) {
 	c.once.Do(func() {
+		closed = true
+		c.queueMu.Lock()
 		c.cancelFunc()
+		var dropped int
+		for _, ok := c.userPackets.TryRecv(); ok; _, ok = c.userPackets.TryRecv() {
+			dropped++
+		}
+		c.queueMu.Unlock()
+		c.conn.metrics.AddGauge(MetricSessions, -1, "server", c.conn.key)
+		c.conn.metrics.AddGauge(MetricSessionQueueDepth, -float64(dropped), "server", c.conn.key)
+		c.conn.handler.DispatchEvent(func(h EventHandler) { h.OnSessionClose(c.conn.key, c.sessionId, reason) })
+		c.span.AddEvent(EventSessionClose, Attr("reason", reason))
+		c.span.SetAttributes(Attr("acks", c.acks.Load()))
+		c.span.End()
 	})
+	return closed
 }
 
 func (c *clientConn) SetDeadline(t time.Time) error {
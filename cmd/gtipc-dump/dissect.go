@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gameparrot/gtipc/capture"
+	"github.com/gameparrot/gtipc/ipcprotocol"
+	"github.com/gameparrot/gtipc/ipcprotocol/rak2user"
+	"github.com/gameparrot/gtipc/ipcprotocol/user2rak"
+	"github.com/gameparrot/gtipc/utils"
+	"github.com/sandertv/gophertunnel/minecraft/protocol/packet"
+)
+
+var errEncrypted = errors.New("batch is encrypted")
+
+// dissector prints IPC records in a human readable form, decoding the Minecraft batches in session payloads.
+type dissector struct {
+	w      io.Writer
+	json   bool
+	inner  bool
+	legacy bool
+
+	mu       sync.Mutex
+	sessions map[sessionKey]*sessionState
+
+	clientPool, serverPool packet.Pool
+}
+
+type sessionKey struct {
+	serverKey string
+	sessionID int32
+}
+
+// sessionState tracks the state of the Minecraft connection of a session needed to decode its batches.
+type sessionState struct {
+	compressed bool
+	encrypted  bool
+}
+
+// jsonRecord is the JSON form of a dissected record.
+type jsonRecord struct {
+	Time      time.Time          `json:"time"`
+	Direction string             `json:"direction"`
+	Server    string             `json:"server"`
+	SessionID int32              `json:"session_id,omitempty"`
+	Packet    string             `json:"packet"`
+	Fields    ipcprotocol.Packet `json:"fields,omitempty"`
+	Inner     []string           `json:"inner,omitempty"`
+	Error     string             `json:"error,omitempty"`
+}
+
+func newDissector(w io.Writer, jsonOut, inner, legacy bool) *dissector {
+	return &dissector{
+		w:          w,
+		json:       jsonOut,
+		inner:      inner,
+		legacy:     legacy,
+		sessions:   make(map[sessionKey]*sessionState),
+		clientPool: packet.NewClientPool(),
+		serverPool: packet.NewServerPool(),
+	}
+}
+
+// print prints a single record. It is safe for concurrent use.
+func (d *dissector) print(rec capture.Record) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	out := jsonRecord{Time: rec.Time, Direction: rec.Direction.String(), Server: rec.ServerKey, SessionID: rec.SessionID}
+	pk, err := rec.Packet()
+	if err != nil {
+		out.Packet = "Invalid"
+		out.Error = err.Error()
+	} else {
+		out.Packet = strings.TrimPrefix(fmt.Sprintf("%T", pk), "*")
+		out.Fields = pk
+		out.Inner, err = d.handlePacket(rec, pk)
+		if err != nil {
+			out.Error = err.Error()
+		}
+	}
+
+	if d.json {
+		b, err := json.Marshal(out)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(d.w, "%s\n", b)
+		return err
+	}
+	line := fmt.Sprintf("%s %s [%s]", out.Time.Format("15:04:05.000000"), out.Direction, out.Server)
+	if out.SessionID != 0 {
+		line += fmt.Sprintf(" session=%d", out.SessionID)
+	}
+	line += " " + out.Packet
+	if pk != nil {
+		line += " " + describe(pk)
+	}
+	if len(out.Inner) > 0 {
+		line += ": " + strings.Join(out.Inner, ", ")
+	}
+	if out.Error != "" {
+		line += " (" + out.Error + ")"
+	}
+	_, err = fmt.Fprintln(d.w, line)
+	return err
+}
+
+// handlePacket updates the session state for a packet and returns the names of the Minecraft packets it holds.
+func (d *dissector) handlePacket(rec capture.Record, pk ipcprotocol.Packet) ([]string, error) {
+	key := sessionKey{serverKey: rec.ServerKey, sessionID: rec.SessionID}
+	switch pk := pk.(type) {
+	case *rak2user.OpenSession:
+		d.sessions[key] = &sessionState{}
+	case *rak2user.CloseSession, *user2rak.CloseSession:
+		delete(d.sessions, key)
+	case *rak2user.Encapsulated:
+		if pk.SessionID > 0 && d.inner {
+			return d.decodeBatch(key, pk.UserPayload, d.clientPool)
+		}
+	case *user2rak.Encapsulated:
+		if pk.SessionID > 0 && d.inner {
+			return d.decodeBatch(key, pk.UserPayload, d.serverPool)
+		}
+	}
+	return nil, nil
+}
+
+// decodeBatch decodes a Minecraft batch sent in a session and returns the names of the packets in it.
+func (d *dissector) decodeBatch(key sessionKey, payload []byte, pool packet.Pool) ([]string, error) {
+	state, ok := d.sessions[key]
+	if !ok {
+		// The session was opened before the capture started.
+		state = &sessionState{}
+		d.sessions[key] = state
+	}
+	if state.encrypted {
+		return nil, errEncrypted
+	}
+
+	decoder := utils.NewDecoder(batchReader(payload))
+	decoder.DisableBatchPacketLimit()
+	if d.legacy {
+		decoder.EnableCompression()
+	} else if state.compressed {
+		decoder.EnableCompression()
+		decoder.EnableAlgorithmHeader()
+	}
+	batch, err := decoder.Decode()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(batch))
+	for _, data := range batch {
+		var h packet.Header
+		if err := h.Read(bytes.NewBuffer(data)); err != nil {
+			names = append(names, "InvalidHeader")
+			continue
+		}
+		if f, ok := pool[h.PacketID]; ok {
+			names = append(names, strings.TrimPrefix(fmt.Sprintf("%T", f()), "*packet."))
+		} else {
+			names = append(names, fmt.Sprintf("Unknown(%d)", h.PacketID))
+		}
+		switch h.PacketID {
+		case packet.IDNetworkSettings:
+			state.compressed = true
+		case packet.IDServerToClientHandshake:
+			state.encrypted = true
+		}
+	}
+	return names, nil
+}
+
+// describe returns a short description of the fields of an IPC packet, leaving out payloads.
+func describe(pk ipcprotocol.Packet) string {
+	switch pk := pk.(type) {
+	case *rak2user.Encapsulated:
+		return fmt.Sprintf("len=%d", len(pk.UserPayload))
+	case *user2rak.Encapsulated:
+		return fmt.Sprintf("len=%d flags=%d reliability=%d ack=%d", len(pk.UserPayload), pk.Flags, pk.Reliability, pk.Ack)
+	case *rak2user.Raw:
+		return fmt.Sprintf("addr=%s port=%d len=%d", pk.Addr, pk.Port, len(pk.Payload))
+	case *user2rak.Raw:
+		return fmt.Sprintf("addr=%s port=%d len=%d", pk.Addr, pk.Port, len(pk.Payload))
+	case *user2rak.SetName:
+		return fmt.Sprintf("%q", pk.Name)
+	}
+	return fmt.Sprintf("%+v", pk)
+}
+
+// batchReader passes a single batch to a utils.Decoder.
+type batchReader []byte
+
+func (b batchReader) Read([]byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (b batchReader) ReadPacket() ([]byte, error) {
+	return b, nil
+}
@@ -0,0 +1,93 @@
+// Command gtipc-dump prints IPC traffic between a proxy and PM servers in a human readable form. It either reads a
+// capture written by gtipc-record:
+//
+//	gtipc-dump -r traffic.gtipcap
+//
+// or sits between PM servers and a proxy, forwarding and printing traffic as it passes:
+//
+//	gtipc-dump -listen /tmp/gtipc-dump.sock -target /tmp/gtipc.sock
+//
+// Session payloads are decoded as Minecraft batches, showing the names of the packets in them.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gameparrot/gtipc/capture"
+	"github.com/gameparrot/gtipc/ipcprotocol/versions"
+)
+
+func main() {
+	var (
+		in            = flag.String("r", "", "capture file to read")
+		listen        = flag.String("listen", "", "socket path to listen on as a man-in-the-middle")
+		target        = flag.String("target", "", "socket path to forward traffic to as a man-in-the-middle")
+		serverListens = flag.Bool("server-listens", false, "set if the PM server listens on the target path, as with an IpcClient")
+		jsonOut       = flag.Bool("json", false, "print records as JSON, one object per line")
+		inner         = flag.Bool("inner", true, "decode Minecraft batches in session payloads")
+		legacy        = flag.Bool("legacy", false, "decode batches as legacy flate compressed batches without an algorithm byte")
+		version       = flag.Uint("protocol", 1, "version of the protocol layout of the traffic when sitting between servers and a proxy")
+	)
+	flag.Parse()
+
+	d := newDissector(os.Stdout, *jsonOut, *inner, *legacy)
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var err error
+	switch {
+	case *in != "":
+		err = dumpFile(*in, d)
+	case *listen != "" && *target != "":
+		p, ok := versions.Lookup(uint8(*version))
+		if !ok || *version > 255 {
+			slog.Error("Unsupported protocol version", "version", *version)
+			os.Exit(2)
+		}
+		i := &capture.Interceptor{
+			ListenPath:    *listen,
+			TargetPath:    *target,
+			ServerListens: *serverListens,
+			Protocol:      p,
+			OnRecord:      d.print,
+		}
+		err = i.ListenAndServe(ctx)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		slog.Error("gtipc-dump failed", "err", err.Error())
+		os.Exit(1)
+	}
+}
+
+func dumpFile(path string, d *dissector) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := capture.NewReader(f)
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := d.print(rec); err != nil {
+			return err
+		}
+	}
+}
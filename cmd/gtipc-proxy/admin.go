@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gameparrot/gtipc"
+)
+
+type playerJSON struct {
+	Name    string    `json:"name"`
+	XUID    string    `json:"xuid"`
+	UUID    string    `json:"uuid"`
+	Address string    `json:"address"`
+	Backend string    `json:"backend"`
+	Joined  time.Time `json:"joined"`
+}
+
+// adminHandler returns the handler of the admin interface. Requests other than GET /players are served by the
+// admin handler of the IPC server.
+func (p *proxy) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /players", p.handlePlayers)
+	mux.Handle("/", gtipc.NewAdminHandler(p.ipc))
+	return mux
+}
+
+func (p *proxy) handlePlayers(w http.ResponseWriter, _ *http.Request) {
+	players := p.snapshot()
+	resp := make([]playerJSON, 0, len(players))
+	for _, pl := range players {
+		conn := pl.session.Player()
+		identity := conn.IdentityData()
+		resp = append(resp, playerJSON{
+			Name:    identity.DisplayName,
+			XUID:    identity.XUID,
+			UUID:    identity.Identity,
+			Address: conn.RemoteAddr().String(),
+			Backend: pl.session.Key(),
+			Joined:  pl.joined,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"time"
+)
+
+// Config is the configuration of the proxy, read from a JSON file.
+type Config struct {
+	// Listen is the address players connect to
+	Listen string `json:"listen"`
+	// Socket is the path of the unix socket PM servers connect to
+	Socket string `json:"socket"`
+	// Backends are the keys of the PM servers behind the proxy. Players join the first one
+	Backends []string `json:"backends"`
+	// AuthenticationDisabled allows players to join without Xbox Live authentication
+	AuthenticationDisabled bool `json:"authentication_disabled"`
+	// Status is reported to players pinging the proxy
+	Status StatusConfig `json:"status"`
+	// Block holds addresses that are blocked when the proxy starts
+	Block []BlockConfig `json:"block"`
+	// Admin configures the admin interface
+	Admin AdminConfig `json:"admin"`
+	// Log configures logging
+	Log LogConfig `json:"log"`
+	// ShutdownTimeout is how long to wait for the admin interface and the IPC server to stop on shutdown
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+}
+
+// StatusConfig configures the status reported to players pinging the proxy.
+type StatusConfig struct {
+	// MOTD replaces the MOTD of the backends if set
+	MOTD string `json:"motd"`
+	// SubMOTD replaces the sub MOTD of the backends if set
+	SubMOTD string `json:"sub_motd"`
+	// MaxPlayers is reported when no backend is connected
+	MaxPlayers int `json:"max_players"`
+}
+
+// BlockConfig is an address blocked when the proxy starts.
+type BlockConfig struct {
+	Address string `json:"address"`
+	// Duration of the block. Addresses without a duration are blocked until the proxy stops
+	Duration Duration `json:"duration"`
+}
+
+// AdminConfig configures the admin interface.
+type AdminConfig struct {
+	// Listen is the address the admin interface listens on. The admin interface is disabled if empty
+	Listen string `json:"listen"`
+}
+
+// LogConfig configures logging.
+type LogConfig struct {
+	// Level is one of debug, info, warn or error
+	Level string `json:"level"`
+	// Format is text or json
+	Format string `json:"format"`
+}
+
+// Duration is a time.Duration that is written as a string in JSON, such as "1h30m".
+type Duration time.Duration
+
+// UnmarshalJSON ...
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON ...
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// defaultConfig returns the config used for fields missing in the config file.
+func defaultConfig() Config {
+	return Config{
+		Listen:          "0.0.0.0:19132",
+		Socket:          "/tmp/gtipc.sock",
+		Backends:        []string{"default"},
+		Status:          StatusConfig{MaxPlayers: 100},
+		Log:             LogConfig{Level: "info", Format: "text"},
+		ShutdownTimeout: Duration(10 * time.Second),
+	}
+}
+
+// loadConfig reads the config file at the path and validates it.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("decode %s: %w", path, err)
+	}
+	return cfg, cfg.validate()
+}
+
+func (cfg Config) validate() error {
+	if len(cfg.Backends) == 0 {
+		return errors.New("at least one backend is required")
+	}
+	for i, key := range cfg.Backends {
+		if key == "" || len(key) > 255 {
+			return fmt.Errorf("backend key %q must be between 1 and 255 bytes", key)
+		}
+		if slices.Contains(cfg.Backends[:i], key) {
+			return fmt.Errorf("backend %q is listed twice", key)
+		}
+	}
+	for _, b := range cfg.Block {
+		if net.ParseIP(b.Address) == nil {
+			return fmt.Errorf("blocked address %q is not an IP address", b.Address)
+		}
+	}
+	if _, err := cfg.Log.handler(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// handler returns the slog handler writing to stderr for the config.
+func (cfg LogConfig) handler() (slog.Handler, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("log level: %w", err)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch cfg.Format {
+	case "text":
+		return slog.NewTextHandler(os.Stderr, opts), nil
+	case "json":
+		return slog.NewJSONHandler(os.Stderr, opts), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+}
@@ -0,0 +1,52 @@
+// Command gtipc-proxy is a Bedrock proxy in front of PM servers connected over the RakLib IPC protocol.
+//
+// PM servers connect to the unix socket set in the config, sending their backend key as their name. Players join
+// the first backend in the config, and are moved between backends without reconnecting when a backend sends a
+// transfer command encoded with gtipc.EncodeTransferCommand. The proxy is configured with a JSON file:
+//
+//	{
+//		"listen": "0.0.0.0:19132",
+//		"socket": "/tmp/gtipc.sock",
+//		"backends": ["lobby", "survival"],
+//		"status": {"motd": "My Network", "max_players": 500},
+//		"block": [{"address": "203.0.113.7", "duration": "24h"}],
+//		"admin": {"listen": "127.0.0.1:19180"},
+//		"log": {"level": "info", "format": "json"}
+//	}
+//
+// The admin interface serves JSON over HTTP. GET /players lists the players on the proxy, and the endpoints of
+// gtipc.AdminHandler list backends and sessions, close sessions, send custom packets and block addresses.
+//
+// On SIGINT or SIGTERM, the proxy stops accepting players, disconnects the players on it and shuts the IPC server
+// down, notifying the PM servers.
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	configPath := flag.String("config", "gtipc-proxy.json", "path of the config file")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		slog.Error("Failed to load config", "path", *configPath, "err", err.Error())
+		os.Exit(1)
+	}
+	h, _ := cfg.Log.handler()
+	log := slog.New(h)
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	if err := run(ctx, cfg, log); err != nil {
+		log.Error("gtipc-proxy failed", "err", err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gameparrot/gtipc"
+	"github.com/sandertv/gophertunnel/minecraft"
+)
+
+// permanentBlock is the duration of blocks without a duration.
+const permanentBlock = 100 * 365 * 24 * time.Hour
+
+// upstreamNetwork is the name of the gophertunnel network players connect through.
+const upstreamNetwork = "gtipc-proxy-raknet"
+
+// proxy accepts players and pairs each of them with a session on a backend.
+type proxy struct {
+	cfg Config
+	log *slog.Logger
+
+	ipc      *gtipc.IpcServer
+	upstream *gtipc.UpstreamHandler
+	listener *minecraft.Listener
+
+	mu      sync.Mutex
+	players map[string]*player
+}
+
+// player is a player connected to the proxy.
+type player struct {
+	session *gtipc.ProxySession
+	joined  time.Time
+}
+
+func run(ctx context.Context, cfg Config, log *slog.Logger) error {
+	p := &proxy{cfg: cfg, log: log, players: make(map[string]*player)}
+	p.upstream = gtipc.CreateGophertunnelUpstreamHandler(upstreamNetwork)
+	defer p.upstream.Close()
+
+	ipc, err := gtipc.NewIPCServer(cfg.Socket, &gtipc.IpcOptions{
+		Upstream:     p.upstream,
+		PongRewriter: p.rewritePong,
+		EventHandler: eventLogger{log: log},
+		Log:          log,
+	})
+	if err != nil {
+		return err
+	}
+	p.ipc = ipc
+	defer ipc.Close()
+
+	for _, b := range cfg.Block {
+		d := time.Duration(b.Duration)
+		if d == 0 {
+			d = permanentBlock
+		}
+		ipc.BlockAddress(net.ParseIP(b.Address), d)
+	}
+
+	fallback := minecraft.ServerStatus{ServerName: cfg.Status.MOTD, ServerSubName: cfg.Status.SubMOTD, MaxPlayers: cfg.Status.MaxPlayers}
+	p.listener, err = minecraft.ListenConfig{
+		AuthenticationDisabled: cfg.AuthenticationDisabled,
+		StatusProvider:         gtipc.NewAggregateStatusProvider(ipc, cfg.Backends[0], cfg.Backends[1:], fallback),
+	}.Listen(upstreamNetwork, cfg.Listen)
+	if err != nil {
+		return err
+	}
+	log.Info("Proxy listening", "addr", cfg.Listen, "socket", cfg.Socket, "backends", cfg.Backends)
+
+	var admin *http.Server
+	adminErr := make(chan error, 1)
+	if cfg.Admin.Listen != "" {
+		admin = &http.Server{Addr: cfg.Admin.Listen, Handler: p.adminHandler()}
+		go func() {
+			log.Info("Admin interface listening", "addr", cfg.Admin.Listen)
+			if err := admin.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				adminErr <- err
+			}
+		}()
+	}
+
+	go p.accept()
+
+	select {
+	case <-ctx.Done():
+		err = nil
+	case err = <-adminErr:
+	}
+	log.Info("Shutting down")
+	p.disconnectAll("Proxy is shutting down")
+	p.listener.Close()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(p.cfg.ShutdownTimeout))
+	defer cancel()
+	if admin != nil {
+		if err := admin.Shutdown(shutdownCtx); err != nil {
+			log.Error("Failed to shut down admin interface", "err", err.Error())
+		}
+	}
+	if err := ipc.Shutdown(shutdownCtx); err != nil {
+		log.Error("Failed to shut down IPC server", "err", err.Error())
+	}
+	return err
+}
+
+// accept accepts players until the listener is closed.
+func (p *proxy) accept() {
+	for {
+		c, err := p.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			p.log.Error("Failed to accept player", "err", err.Error())
+			continue
+		}
+		go p.handlePlayer(c.(*minecraft.Conn))
+	}
+}
+
+// handlePlayer connects a player to the first backend and keeps track of it until it leaves.
+func (p *proxy) handlePlayer(conn *minecraft.Conn) {
+	identity := conn.IdentityData()
+	log := p.log.With("player", identity.DisplayName, "xuid", identity.XUID, "addr", conn.RemoteAddr().String())
+
+	session := gtipc.NewProxySession(p.ipc, conn)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := session.Connect(ctx, p.cfg.Backends[0]); err != nil {
+		log.Warn("Failed to connect player to backend", "backend", p.cfg.Backends[0], "err", err.Error())
+		p.listener.Disconnect(conn, "Could not connect to the server")
+		return
+	}
+	log.Info("Player joined", "backend", p.cfg.Backends[0])
+
+	p.mu.Lock()
+	p.players[identity.Identity] = &player{session: session, joined: time.Now()}
+	p.mu.Unlock()
+
+	<-session.Done()
+
+	p.mu.Lock()
+	delete(p.players, identity.Identity)
+	p.mu.Unlock()
+	log.Info("Player left")
+}
+
+// disconnectAll disconnects all players with the message passed.
+func (p *proxy) disconnectAll(message string) {
+	p.mu.Lock()
+	players := make([]*player, 0, len(p.players))
+	for _, pl := range p.players {
+		players = append(players, pl)
+	}
+	p.mu.Unlock()
+	for _, pl := range players {
+		p.listener.Disconnect(pl.session.Player(), message)
+		pl.session.Close()
+	}
+}
+
+// rewritePong replaces the MOTD reported by backends with the one in the config, if set.
+func (p *proxy) rewritePong(_ string, pong *gtipc.Pong) {
+	if p.cfg.Status.MOTD != "" {
+		pong.MOTD = p.cfg.Status.MOTD
+	}
+	if p.cfg.Status.SubMOTD != "" {
+		pong.SubMOTD = p.cfg.Status.SubMOTD
+	}
+}
+
+// snapshot returns the players on the proxy sorted by name.
+func (p *proxy) snapshot() []*player {
+	p.mu.Lock()
+	players := make([]*player, 0, len(p.players))
+	for _, pl := range p.players {
+		players = append(players, pl)
+	}
+	p.mu.Unlock()
+	slices.SortFunc(players, func(a, b *player) int {
+		return strings.Compare(a.session.Player().IdentityData().DisplayName, b.session.Player().IdentityData().DisplayName)
+	})
+	return players
+}
+
+// eventLogger logs the lifecycle events of backends.
+type eventLogger struct {
+	gtipc.NopEventHandler
+	log *slog.Logger
+}
+
+func (e eventLogger) OnServerConnect(key string) {
+	e.log.Info("Backend connected", "backend", key)
+}
+
+func (e eventLogger) OnServerDisconnect(key string) {
+	e.log.Info("Backend disconnected", "backend", key)
+}
+
+func (e eventLogger) OnBlock(key string, addr net.IP, duration time.Duration) {
+	e.log.Info("Backend blocked address", "backend", key, "addr", addr.String(), "duration", duration.String())
+}
+
+func (e eventLogger) OnUnblock(key string, addr net.IP) {
+	e.log.Info("Backend unblocked address", "backend", key, "addr", addr.String())
+}
+
+func (e eventLogger) OnTransferRequested(req gtipc.TransferRequest) {
+	e.log.Info("Backend transferred player", "from", req.From, "to", req.To, "session", req.SessionID, "addr", req.ClientAddr)
+}
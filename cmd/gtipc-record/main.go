@@ -0,0 +1,115 @@
+// Command gtipc-record records IPC traffic between a proxy and PM servers to a capture file, and replays captures.
+//
+// To record, point PM servers (or an IpcClient) at the listen path, and the recorder forwards all traffic to the
+// target path:
+//
+//	gtipc-record -listen /tmp/gtipc-record.sock -target /tmp/gtipc.sock -o traffic.gtipcap
+//
+// To replay the frames sent by PM servers into a fresh IpcServer:
+//
+//	gtipc-record -replay traffic.gtipcap -socket /tmp/gtipc.sock -speed 10
+//
+// With -to peer, the frames sent by the proxy are replayed into a PM server or fake peer listening on the socket.
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gameparrot/gtipc/capture"
+	"github.com/gameparrot/gtipc/ipcprotocol/versions"
+)
+
+func main() {
+	var (
+		listen        = flag.String("listen", "", "socket path to listen on when recording")
+		target        = flag.String("target", "", "socket path to forward recorded traffic to")
+		out           = flag.String("o", "capture.gtipcap", "capture file to write when recording")
+		serverListens = flag.Bool("server-listens", false, "set if the PM server listens on the target path, as with an IpcClient")
+		replay        = flag.String("replay", "", "capture file to replay")
+		socket        = flag.String("socket", "", "socket path to replay into")
+		to            = flag.String("to", "server", "what to replay into: server (an IpcServer) or peer (a PM server)")
+		speed         = flag.Float64("speed", 1, "replay speed factor, 0 replays without delays")
+		version       = flag.Uint("protocol", 1, "version of the protocol layout of recorded servers that don't select one in the handshake")
+	)
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	var err error
+	switch {
+	case *replay != "":
+		err = runReplay(ctx, *replay, *socket, *to, *speed)
+	case *listen != "" && *target != "":
+		err = runRecord(ctx, *listen, *target, *out, *serverListens, *version)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		slog.Error("gtipc-record failed", "err", err.Error())
+		os.Exit(1)
+	}
+}
+
+func runRecord(ctx context.Context, listen, target, out string, serverListens bool, version uint) error {
+	p, ok := versions.Lookup(uint8(version))
+	if !ok || version > 255 {
+		return fmt.Errorf("unsupported protocol version %d", version)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	buf := bufio.NewWriter(f)
+	defer buf.Flush()
+
+	w, err := capture.NewWriter(buf)
+	if err != nil {
+		return err
+	}
+	i := &capture.Interceptor{
+		ListenPath:    listen,
+		TargetPath:    target,
+		ServerListens: serverListens,
+		Protocol:      p,
+		OnRecord:      w.Write,
+	}
+	slog.Info("Recording IPC traffic", "listen", listen, "target", target, "out", out, "protocol", p.Version())
+	return i.ListenAndServe(ctx)
+}
+
+func runReplay(ctx context.Context, in, socket, to string, speed float64) error {
+	if socket == "" {
+		return fmt.Errorf("-socket is required to replay")
+	}
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := capture.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	p := capture.Replayer{Speed: speed}
+	switch to {
+	case "server":
+		p.Direction, p.Dial = capture.DirectionServerToProxy, capture.DialIpcServer(socket)
+	case "peer":
+		p.Direction, p.Dial = capture.DirectionProxyToServer, capture.DialPeer(socket)
+	default:
+		return fmt.Errorf("unknown replay target %q", to)
+	}
+	slog.Info("Replaying capture", "in", in, "socket", socket, "to", to, "speed", speed)
+	return p.Replay(ctx, r)
+}
@@ -2,65 +2,120 @@ package gtipc
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"log/slog"
 	"math/rand"
 	"net"
 	"net/netip"
-	"strconv"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	goio "io"
 
 	"github.com/gameparrot/gtipc/ipcprotocol"
-	"github.com/gameparrot/gtipc/ipcprotocol/io"
 	"github.com/gameparrot/gtipc/ipcprotocol/rak2user"
 	"github.com/gameparrot/gtipc/ipcprotocol/user2rak"
 )
 
+// Conn states. A conn starts open, and moves to closing and then closed when it is closed, never moving back.
+const (
+	// connStateOpen is the state of a conn that sessions can be opened on
+	connStateOpen int32 = iota
+	// connStateClosing is the state of a conn whose sessions are being closed
+	connStateClosing
+	// connStateClosed is the state of a conn whose unix conn is closed
+	connStateClosed
+)
+
 type Conn struct {
 	unixConn net.Conn
-	handler  IpcHandler
+	handler  ConnHandler
+	opts     *IpcOptions
 
-	user2RakPool map[uint8]func() ipcprotocol.Packet
-	rak2UserPool map[uint8]func() ipcprotocol.Packet
+	state atomic.Int32
+	done  chan struct{}
+
+	protocol *ipcprotocol.Protocol
 
 	sessionId int32
 
 	sessionsMut sync.Mutex
 	sessions    map[int32]*clientConn
+	drain       *drainState
 
 	key      string
-	isClient bool
-	pongData []byte
+	role     ConnRole
+	pongData atomic.Pointer[[]byte]
 
 	writeMu sync.Mutex
 
 	reader *packetReader
 
+	metrics       Metrics
+	user2RakNames map[uint8]string
+	rak2UserNames map[uint8]string
+
 	log *slog.Logger
 }
 
-// NewConn returns a new conn. Call ReadLoop to start reading packets.
-func NewConn(log *slog.Logger, conn net.Conn, key string, handler IpcHandler) *Conn {
-	_, isClient := handler.(*IpcClient)
-	if !isClient {
+// NewConn returns a new conn that hands what it reads to the handler. The role tells which side of the unix
+// socket the conn is on. Call ReadLoop to start reading packets.
+func NewConn(log *slog.Logger, conn net.Conn, key string, handler ConnHandler, role ConnRole) *Conn {
+	if role == RoleServer {
 		log.Info("Server connected", "key", key)
 	}
-	c := &Conn{unixConn: conn, log: log, user2RakPool: user2rak.NewUser2RakPool(), rak2UserPool: rak2user.NewRak2UserPool(), sessions: make(map[int32]*clientConn), key: key, reader: newPacketReader(), isClient: isClient, handler: handler}
+	opts := handler.Options().withDefaults()
+	c := &Conn{unixConn: conn, log: log, done: make(chan struct{}), sessions: make(map[int32]*clientConn), key: key, reader: newPacketReader(), role: role, handler: handler, opts: opts, metrics: opts.Metrics}
+	c.setProtocol(opts.Protocol)
 	return c
 }
 
-// Read loop
+// Role returns the side of the unix socket the conn is on
+func (c *Conn) Role() ConnRole {
+	return c.role
+}
+
+// setProtocol sets the protocol layout used by the conn. It must be called before ReadLoop.
+func (c *Conn) setProtocol(p *ipcprotocol.Protocol) {
+	c.protocol = p
+	c.user2RakNames, c.rak2UserNames = packetNames(p.User2RakPool()), packetNames(p.Rak2UserPool())
+}
+
+// Protocol returns the protocol layout used by the conn
+func (c *Conn) Protocol() *ipcprotocol.Protocol {
+	return c.protocol
+}
+
+// ReadLoop reads packets until the conn is closed. Session payloads are delivered by the read loop itself, while
+// custom packets and block requests are handed to the queues configured in the options, so that slow handlers
+// don't hold up sessions. ReadLoop returns once the handlers of all queued packets have returned.
 func (c *Conn) ReadLoop() {
+	customQueue, controlQueue := newDispatchQueue(c, queueCustom, c.opts.CustomPacketQueue), newDispatchQueue(c, queueControl, c.opts.ControlQueue)
+	defer func() {
+		customQueue.close()
+		controlQueue.close()
+		customQueue.wg.Wait()
+		controlQueue.wg.Wait()
+	}()
+
 	for {
 		pks, err := c.ReadPacket()
-		if err != nil {
+		var decodeErr *DecodeError
+		if errors.As(err, &decodeErr) {
+			// The frames that failed to decode were skipped, so the packets of the other frames are still handled.
+			c.log.Error("Failed to decode packet", "key", c.key, "err", err.Error())
+		} else if err != nil {
+			if c.state.Load() != connStateOpen {
+				c.Close()
+				return
+			}
 			if errors.Is(err, goio.EOF) {
-				if !c.isClient {
+				if c.role == RoleServer {
 					c.log.Info("Server disconnected", "key", c.key)
 				}
 				c.Close()
@@ -77,27 +132,55 @@ func (c *Conn) ReadLoop() {
 			switch pk := pk.(type) {
 			case *user2rak.Encapsulated:
 				if pk.SessionID == -1 {
-					c.handler.handleCustomPacket(pk.UserPayload, c.key)
+					customQueue.push(func() { c.handler.HandleCustomPacket(pk.UserPayload, c.key) })
 					continue
 				}
 				c.sessionsMut.Lock()
-				if session, ok := c.sessions[pk.SessionID]; ok {
+				session, ok := c.sessions[pk.SessionID]
+				c.sessionsMut.Unlock()
+				if ok {
 					session.handlePacketFromServer(pk.UserPayload, (pk.Flags&(1<<0)) != 0, pk.Ack)
 				}
-				c.sessionsMut.Unlock()
 			case *user2rak.SetName:
-				c.pongData = pk.Name
+				c.setPongData(pk.Name)
 			case *user2rak.CloseSession:
 				c.sessionsMut.Lock()
 				if session, ok := c.sessions[pk.SessionID]; ok {
-					session.internalClose()
+					session.internalClose(rak2user.DisconnectReasonServerDisconnect)
 					delete(c.sessions, pk.SessionID)
+					c.sessionRemoved()
 				}
 				c.sessionsMut.Unlock()
 			case *user2rak.BlockAddress:
-				c.handler.BlockAddress(net.ParseIP(pk.Addr), time.Duration(pk.Timeout)*time.Second)
+				ip, err := parseIP(pk.Addr)
+				if err != nil {
+					c.log.Error("Server sent invalid address to block", "key", c.key, "err", err.Error())
+					continue
+				}
+				if isSessionIDAddr(ip) {
+					// The server blocked a session opened without a client address, which has no address to block.
+					c.log.Debug("Server blocked address of session without client address", "key", c.key, "addr", pk.Addr)
+					continue
+				}
+				addr, duration := net.IP(ip.AsSlice()), time.Duration(pk.Timeout)*time.Second
+				controlQueue.push(func() {
+					c.handler.BlockAddress(addr, duration)
+					c.handler.DispatchEvent(func(h EventHandler) { h.OnBlock(c.key, addr, duration) })
+				})
 			case *user2rak.UnblockAddress:
-				c.handler.UnblockAddress(net.ParseIP(pk.Addr))
+				ip, err := parseIP(pk.Addr)
+				if err != nil {
+					c.log.Error("Server sent invalid address to unblock", "key", c.key, "err", err.Error())
+					continue
+				}
+				if isSessionIDAddr(ip) {
+					continue
+				}
+				addr := net.IP(ip.AsSlice())
+				controlQueue.push(func() {
+					c.handler.UnblockAddress(addr)
+					c.handler.DispatchEvent(func(h EventHandler) { h.OnUnblock(c.key, addr) })
+				})
 			}
 		}
 	}
@@ -106,112 +189,293 @@ func (c *Conn) ReadLoop() {
 func (c *Conn) removeSession(sessionId int32) {
 	c.sessionsMut.Lock()
 	delete(c.sessions, sessionId)
+	c.sessionRemoved()
 	c.sessionsMut.Unlock()
 }
 
 // OpenSession opens a new session on the PM server
 func (c *Conn) OpenSession(clientAddr string) (net.Conn, error) {
+	return c.OpenSessionContext(context.Background(), clientAddr)
+}
+
+// OpenSessionContext opens a new session on the PM server with a random client ID. A span for the session is started
+// from ctx with the tracer in the options of the handler, which ends when the session is closed.
+func (c *Conn) OpenSessionContext(ctx context.Context, clientAddr string) (net.Conn, error) {
+	return c.OpenSessionWithClientID(ctx, clientAddr, rand.Int63())
+}
+
+// OpenSessionWithClientID opens a new session on the PM server like OpenSessionContext, sending the client ID passed
+// instead of a random one. This is the RakNet client ID the server sees for the session.
+func (c *Conn) OpenSessionWithClientID(ctx context.Context, clientAddr string, clientID int64) (net.Conn, error) {
+	session, err := c.openSession(ctx, clientAddr, clientID, nil)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// openSession opens a new session on the PM server with the client address, ID and metadata passed. The metadata is
+// set before the session is added to the sessions of the conn, where transfers may read it. An error is returned if
+// the client address can't be parsed by ParseClientAddr.
+func (c *Conn) openSession(ctx context.Context, clientAddr string, clientID int64, metadata map[string]string) (*clientConn, error) {
+	_, span := c.opts.Tracer.Start(ctx, SpanDial, Attr("server", c.key), Attr("client_addr", clientAddr))
+
+	var remoteAddr netip.AddrPort
+	if clientAddr != "" {
+		var err error
+		if remoteAddr, err = ParseClientAddr(clientAddr); err != nil {
+			span.RecordError(err)
+			span.End()
+			return nil, err
+		}
+	}
+
 	c.sessionsMut.Lock()
+	if c.state.Load() != connStateOpen {
+		c.sessionsMut.Unlock()
+		span.RecordError(net.ErrClosed)
+		span.End()
+		return nil, net.ErrClosed
+	}
+	if c.drain != nil {
+		c.sessionsMut.Unlock()
+		span.RecordError(ErrDraining)
+		span.End()
+		return nil, ErrDraining
+	}
 
 	c.sessionId++
 	sid := c.sessionId
 
-	var port int
-	var addr []byte
+	var udp *net.UDPAddr
+	sent := remoteAddr
 	if clientAddr == "" {
-		addr = make([]byte, 4)
-		binary.BigEndian.PutUint32(addr, uint32(sid))
+		sent = sessionIDAddr(sid)
 	} else {
-		ip, portStr, err := net.SplitHostPort(clientAddr)
-		if err != nil {
-			addr = make([]byte, 4)
-			binary.BigEndian.PutUint32(addr, uint32(sid))
-		} else {
-			ip, ok := netip.AddrFromSlice(net.ParseIP(ip))
-			if ok {
-				if ip.Is4In6() {
-					ip = ip.Unmap()
-				}
-				addr = ip.AsSlice()
-			}
-			port, _ = strconv.Atoi(portStr)
-		}
+		udp = udpAddr(remoteAddr)
 	}
-
 	err := c.WritePacket(&rak2user.OpenSession{
 		SessionID: sid,
-		Addr:      addr,
-		Port:      uint16(port),
-		ClientID:  rand.Int63(),
+		Addr:      sent.Addr().AsSlice(),
+		Port:      sent.Port(),
+		ClientID:  clientID,
 	})
 	if err != nil {
 		c.sessionsMut.Unlock()
+		span.RecordError(err)
+		span.End()
 		return nil, err
 	}
+	span.SetAttributes(Attr("session_id", sid))
+	span.AddEvent(EventOpenSessionSent)
 
-	clientConn := newClientConn(c, sid)
+	clientConn := newClientConn(c, sid, clientAddr, udp, clientID, metadata, span)
 	c.sessions[sid] = clientConn
 	c.sessionsMut.Unlock()
+	c.metrics.AddGauge(MetricSessions, 1, "server", c.key)
+	c.handler.DispatchEvent(func(h EventHandler) { h.OnSessionOpen(c.key, sid, clientAddr) })
 
 	return clientConn, nil
 }
 
-// Close closes the unix conn and all raknet conns
+// Close closes all raknet conns and the unix conn. The server is told that the sessions were closed if it can
+// still be written to. Calls after the first have no effect.
 func (c *Conn) Close() {
-	if c.unixConn != nil {
-		c.unixConn.Close()
+	c.sessionsMut.Lock()
+	if !c.state.CompareAndSwap(connStateOpen, connStateClosing) {
+		c.sessionsMut.Unlock()
+		return
 	}
+	sessions := make([]*clientConn, 0, len(c.sessions))
 	for _, s := range c.sessions {
-		s.Close()
+		sessions = append(sessions, s)
+	}
+	clear(c.sessions)
+	c.sessionRemoved()
+	c.sessionsMut.Unlock()
+
+	if c.unixConn == nil {
+		c.state.Store(connStateClosed)
+		close(c.done)
+		return
+	}
+	// Don't let a server that stopped reading hold up closing its sessions.
+	c.unixConn.SetWriteDeadline(time.Now().Add(time.Second))
+	for _, s := range sessions {
+		if s.internalClose(rak2user.DisconnectReasonServerShutdown) {
+			c.WritePacket(&rak2user.CloseSession{SessionID: s.sessionId, Reason: rak2user.DisconnectReasonServerShutdown})
+		}
 	}
+	c.state.Store(connStateClosed)
+	c.unixConn.Close()
+	close(c.done)
+}
+
+// Key returns the key of the PM server. For IpcServers, this is the name the server sent when connecting, and for
+// IpcClients the path of the socket.
+func (c *Conn) Key() string {
+	return c.key
+}
+
+// Done returns a channel that is closed once the conn is closed.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Pong returns the pong data last sent by the server, parsed and passed through the pong rewriter in the options of the handler.
+// An error is returned if the server has not sent valid pong data.
+func (c *Conn) Pong() (*Pong, error) {
+	return c.pong()
+}
+
+// PongData returns the raw pong data last sent by the server, or nil if it has not sent any.
+func (c *Conn) PongData() []byte {
+	return c.pongBytes()
+}
+
+// Sessions returns the sessions open on the server, sorted by ID.
+func (c *Conn) Sessions() []Session {
+	c.sessionsMut.Lock()
+	sessions := make([]Session, 0, len(c.sessions))
+	for _, s := range c.sessions {
+		sessions = append(sessions, s)
+	}
+	c.sessionsMut.Unlock()
+	slices.SortFunc(sessions, func(a, b Session) int { return cmp.Compare(a.ID(), b.ID()) })
+	return sessions
+}
+
+// Session returns the open session with the ID, or false if there is none.
+func (c *Conn) Session(id int32) (Session, bool) {
+	c.sessionsMut.Lock()
+	defer c.sessionsMut.Unlock()
+	s, ok := c.sessions[id]
+	if !ok {
+		return nil, false
+	}
+	return s, true
+}
+
+// SendPing reports the ping of the client of a session to the server.
+func (c *Conn) SendPing(sessionID int32, ping time.Duration) error {
+	return c.WritePacket(&rak2user.ReportPing{SessionID: sessionID, Ping: int32(ping.Milliseconds())})
+}
+
+// ReportBandwidth reports the bytes sent and received since the last report to the server. IpcServers with an
+// upstream handler report these every second by themselves.
+func (c *Conn) ReportBandwidth(sent, received int64) error {
+	return c.WritePacket(&rak2user.ReportBandwidthStats{SentBytesDiff: sent, ReceivedBytesDiff: received})
 }
 
 // WritePacket writes an IPC packet to the conn
 func (c *Conn) WritePacket(pk ipcprotocol.Packet) error {
+	if c.state.Load() == connStateClosed {
+		return net.ErrClosed
+	}
+	start := time.Now()
+	frame, err := c.protocol.Encode(pk)
+	if err != nil {
+		return err
+	}
+	var b = make([]byte, 4, len(frame)+4)
+	binary.BigEndian.PutUint32(b, uint32(len(frame)))
 	c.writeMu.Lock()
-	buf := bytes.NewBuffer(nil)
-	buf.WriteByte(pk.ID())
-	writer := io.NewWriter(buf)
-	pk.Marshal(writer, 0)
-	var b = make([]byte, 4)
-	bytes := buf.Bytes()
-	binary.BigEndian.PutUint32(b, uint32(len(bytes)))
-	_, err := c.unixConn.Write(append(b, buf.Bytes()...))
+	n, err := c.unixConn.Write(append(b, frame...))
+	if err == nil && c.opts.FrameObserver != nil {
+		c.opts.FrameObserver(c.key, c.protocol, true, frame)
+	}
 	c.writeMu.Unlock()
+
+	c.metrics.ObserveHistogram(MetricWriteSeconds, time.Since(start).Seconds(), "server", c.key)
+	c.metrics.AddCounter(MetricBytesSent, float64(n), "server", c.key)
+	if err == nil {
+		c.metrics.AddCounter(MetricPacketsSent, 1, "server", c.key, "type", c.rak2UserNames[frame[0]])
+	}
 	return err
 }
 
+// setPongData stores the pong data sent by the server and dispatches OnPongUpdate.
+func (c *Conn) setPongData(data []byte) {
+	c.pongData.Store(&data)
+	c.handler.DispatchEvent(func(h EventHandler) { h.OnPongUpdate(c.key, data) })
+}
+
+// pongBytes returns the pong data last sent by the server, or nil if it has not sent any.
+func (c *Conn) pongBytes() []byte {
+	if b := c.pongData.Load(); b != nil {
+		return *b
+	}
+	return nil
+}
+
+// pong parses the pong data last sent by the server and applies the pong rewriter in the options of the handler to it
+func (c *Conn) pong() (*Pong, error) {
+	pong, err := ParsePong(c.pongBytes())
+	if err != nil {
+		return nil, err
+	}
+	if c.opts.PongRewriter != nil {
+		c.opts.PongRewriter(c.key, pong)
+	}
+	return pong, nil
+}
+
+// pingResponse returns the pong data to respond to pings with. Pong data that can't be parsed is returned as is.
+func (c *Conn) pingResponse() []byte {
+	pong, err := c.pong()
+	if err != nil {
+		return c.pongBytes()
+	}
+	return pong.Marshal()
+}
+
 // WriteCustomPacket writes a custom packet (Encapsulated with session id set to -1)
-func (c *Conn) WriteCustomPacket(b []byte) {
-	c.WritePacket(&rak2user.Encapsulated{
+func (c *Conn) WriteCustomPacket(b []byte) error {
+	return c.WritePacket(&rak2user.Encapsulated{
 		SessionID:   -1,
 		UserPayload: b,
 	})
 }
 
-// ReadPacket reads an IPC packet from the conn
+// DecodeError is returned by Conn.ReadPacket for a frame that could not be decoded.
+type DecodeError struct {
+	// Frame holds the packet ID followed by the payload, without the length prefix
+	Frame []byte
+	// Err is the error decoding the frame
+	Err error
+}
+
+// Error ...
+func (e *DecodeError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap ...
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ReadPacket reads the IPC packets of the next frames received on the conn. Frames that can't be decoded are
+// skipped: the packets decoded from the other frames are returned together with a *DecodeError for each of them.
 func (c *Conn) ReadPacket() (packets []ipcprotocol.Packet, err error) {
 	pks, err := c.reader.takePackets(c.unixConn)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		if r := recover(); r != nil {
-			if rErr, ok := r.(error); ok {
-				err = rErr
-			}
-		}
-	}()
+	var errs []error
 	for _, pk := range pks {
-		packetFunc, ok := c.user2RakPool[pk[0]]
-		if !ok {
-			return packets, fmt.Errorf("invalid packet id %d", pk[0])
+		c.metrics.AddCounter(MetricBytesReceived, float64(len(pk)+4), "server", c.key)
+		if c.opts.FrameObserver != nil {
+			c.opts.FrameObserver(c.key, c.protocol, false, pk)
+		}
+		packet, err := ipcprotocol.Decode(c.protocol.User2RakPool(), pk)
+		if err != nil {
+			c.metrics.AddCounter(MetricDecodeErrors, 1, "server", c.key)
+			errs = append(errs, &DecodeError{Frame: bytes.Clone(pk), Err: err})
+			continue
 		}
-		reader := bytes.NewReader(pk[1:])
-		pkReader := io.NewReader(reader)
-		packet := packetFunc()
-		packet.Marshal(pkReader, len(pk)-1)
+		c.metrics.AddCounter(MetricPacketsReceived, 1, "server", c.key, "type", c.user2RakNames[pk[0]])
 		packets = append(packets, packet)
 	}
-	return packets, nil
+	return packets, errors.Join(errs...)
 }
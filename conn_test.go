@@ -0,0 +1,272 @@
+package gtipc_test
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gameparrot/gtipc"
+	"github.com/gameparrot/gtipc/gtipctest"
+	"github.com/gameparrot/gtipc/ipcprotocol"
+	"github.com/gameparrot/gtipc/ipcprotocol/user2rak"
+	"github.com/gameparrot/gtipc/ipcprotocol/versions"
+)
+
+// discardLog is a logger that discards everything logged.
+var discardLog = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// nopHandler is a ConnHandler that ignores everything the conn reads besides session payloads.
+type nopHandler struct {
+	opts *gtipc.IpcOptions
+}
+
+func (nopHandler) HandleCustomPacket([]byte, string)        {}
+func (nopHandler) BlockAddress(net.IP, time.Duration)       {}
+func (nopHandler) UnblockAddress(net.IP)                    {}
+func (nopHandler) DispatchEvent(func(h gtipc.EventHandler)) {}
+func (h nopHandler) Options() *gtipc.IpcOptions             { return h.opts }
+
+// appendFrame appends a frame to b, prefixed with its length.
+func appendFrame(b, frame []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(b, uint32(len(frame))), frame...)
+}
+
+// FuzzReadPacket writes a stream to a conn and reads it with ReadPacket. Frames that fail to decode must be skipped
+// without losing the packets of the other frames.
+func FuzzReadPacket(f *testing.F) {
+	var stream []byte
+	for _, pk := range []ipcprotocol.Packet{
+		&user2rak.SetName{Name: []byte("MCPE;Server;712;1.21.20;0;20;1;Sub;Survival;1;19132;19133;")},
+		&user2rak.Encapsulated{SessionID: 1, Reliability: 3, UserPayload: []byte{0xfe, 0x01}},
+		&user2rak.BlockAddress{Addr: "203.0.113.7", Timeout: 60},
+	} {
+		frame, _ := versions.V1.Encode(pk)
+		stream = appendFrame(stream, frame)
+	}
+	f.Add(stream)
+	// A truncated Encapsulated packet followed by a valid one.
+	f.Add(appendFrame(appendFrame(nil, []byte{user2rak.IdEncapsulated, 0, 0}), []byte{user2rak.IdCloseSession, 0, 0, 0, 1}))
+	f.Add(appendFrame(nil, nil))
+
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		var want []ipcprotocol.Packet
+		var frames int
+		for rest := stream; len(rest) >= 4; frames++ {
+			l := binary.BigEndian.Uint32(rest)
+			if uint64(len(rest)-4) < uint64(l) {
+				break
+			}
+			if pk, err := ipcprotocol.Decode(versions.V1.User2RakPool(), rest[4:4+l]); err == nil {
+				want = append(want, pk)
+			}
+			rest = rest[4+l:]
+		}
+
+		client, server := net.Pipe()
+		go func() {
+			server.Write(stream)
+			server.Close()
+		}()
+		c := gtipc.NewConn(discardLog, client, "fuzz", nopHandler{}, gtipc.RoleClient)
+		defer c.Close()
+
+		var got []ipcprotocol.Packet
+		var decodeErrs int
+		for {
+			pks, err := c.ReadPacket()
+			got = append(got, pks...)
+			if err == nil {
+				continue
+			}
+			var decodeErr *gtipc.DecodeError
+			if !errors.As(err, &decodeErr) {
+				break
+			}
+			for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+				if !errors.As(err, &decodeErr) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				decodeErrs++
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("got packets %#v, want %#v", got, want)
+		}
+		if len(got)+decodeErrs != frames {
+			t.Fatalf("got %d packets and %d decode errors, want %d frames", len(got), decodeErrs, frames)
+		}
+	})
+}
+
+// connectServer starts an IpcServer with a fake PM server connected to it and returns both, along with the conn of
+// the fake server.
+func connectServer(t *testing.T) (*gtipc.IpcServer, *gtipctest.Server, *gtipc.Conn) {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "gtipc.sock")
+	s, err := gtipc.NewIPCServer(sock, &gtipc.IpcOptions{Log: discardLog})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	fake, err := gtipctest.Connect(sock, "lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fake.Close() })
+	for range 1000 {
+		if conn, ok := s.GetConn("lobby"); ok {
+			return s, fake, conn
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("fake server did not connect")
+	return nil, nil, nil
+}
+
+// TestConnCloseRace closes conns, from the proxy and from the server, while sessions are opened, written to and
+// closed from both sides and the server is pinged. Run with -race.
+func TestConnCloseRace(t *testing.T) {
+	for i := range 20 {
+		s, fake, conn := connectServer(t)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; ctx.Err() == nil; j++ {
+					session, err := conn.OpenSessionContext(ctx, "203.0.113.7:19132")
+					if err != nil {
+						return
+					}
+					_, _ = session.Write([]byte{0xfe})
+					if j%2 == 0 {
+						_ = session.Close()
+					}
+				}
+			}()
+		}
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				for _, session := range conn.Sessions() {
+					_ = session.CloseWithReason(0)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_ = fake.Serve(ctx, func(session *gtipctest.Session) {
+				_, _ = session.Write([]byte{0xfe})
+				_ = session.Close()
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				_ = fake.SetPong([]byte("MCPE;Lobby;712;1.21.20;0;20;1;gtipc;Survival;1;19132;19133;"))
+				_, _ = s.PingContext(ctx, "lobby")
+			}
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		if i%2 == 0 {
+			conn.Close()
+		} else {
+			// The read loop closes the conn once the server disconnects.
+			_ = fake.Close()
+		}
+		select {
+		case <-conn.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("conn was not closed")
+		}
+		if _, err := conn.OpenSession(""); err == nil {
+			t.Fatal("opened a session on a closed conn")
+		}
+		cancel()
+		wg.Wait()
+		if sessions := conn.Sessions(); len(sessions) != 0 {
+			t.Fatalf("closed conn has %d sessions", len(sessions))
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestConnConcurrentClose closes a conn from several goroutines while its read loop handles packets of the server.
+func TestConnConcurrentClose(t *testing.T) {
+	for range 20 {
+		_, fake, conn := connectServer(t)
+		for range 10 {
+			if _, err := conn.OpenSession(""); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, session := range fake.Sessions() {
+				_, _ = session.Write([]byte{0xfe})
+				_ = session.Close()
+			}
+		}()
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn.Close()
+			}()
+		}
+		wg.Wait()
+		<-conn.Done()
+		if sessions := conn.Sessions(); len(sessions) != 0 {
+			t.Fatalf("closed conn has %d sessions", len(sessions))
+		}
+	}
+}
+
+func TestSessionRemoteAddr(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, fake, conn := connectServer(t)
+
+	c, err := conn.OpenSession("203.0.113.7:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := c.RemoteAddr(); addr.Network() != "udp" || addr.String() != "203.0.113.7:19132" {
+		t.Fatalf("session has remote address %s/%s, want the client address", addr.Network(), addr)
+	}
+	if _, err := fake.Accept(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a client address, the server is sent an address made up from the session ID, which is not exposed.
+	c, err = conn.OpenSession("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := c.RemoteAddr(); addr.Network() != "ipc" || addr.String() != c.LocalAddr().String() {
+		t.Fatalf("session without client address has remote address %s/%s, want its IPC address", addr.Network(), addr)
+	}
+	fs, err := fake.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip4 := fs.Addr.To4(); ip4 == nil || ip4[0] != 0 {
+		t.Fatalf("server was sent address %s for a session without client address, want one in 0.0.0.0/8", fs.Addr)
+	}
+}
@@ -0,0 +1,75 @@
+package gtipc
+
+import "sync"
+
+// Names of the queues that Conn.ReadLoop hands packets to, used as metric labels.
+const (
+	queueCustom  = "custom"
+	queueControl = "control"
+)
+
+// DispatchQueue configures a queue that Conn.ReadLoop hands packets to, so that slow handlers don't hold up the
+// payloads of sessions, which are always delivered by the read loop itself.
+type DispatchQueue struct {
+	// Workers is the amount of goroutines that handle packets from the queue. Packets are handled in the order they
+	// were received only if there is a single worker. Defaults to 1
+	Workers int
+	// Size is the amount of packets the queue holds before it overflows. Defaults to 1024
+	Size int
+	// DropOnOverflow drops packets when the queue is full. Otherwise, the read loop waits for space in the queue,
+	// holding up all traffic of the server
+	DropOnOverflow bool
+}
+
+// dispatchQueue runs functions on a fixed amount of worker goroutines.
+type dispatchQueue struct {
+	name string
+	conn *Conn
+	drop bool
+
+	ch chan func()
+	wg sync.WaitGroup
+}
+
+func newDispatchQueue(conn *Conn, name string, cfg DispatchQueue) *dispatchQueue {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.Size <= 0 {
+		cfg.Size = 1024
+	}
+	q := &dispatchQueue{name: name, conn: conn, drop: cfg.DropOnOverflow, ch: make(chan func(), cfg.Size)}
+	q.wg.Add(cfg.Workers)
+	for range cfg.Workers {
+		go q.work()
+	}
+	return q
+}
+
+// push queues f. If the queue is full, f is dropped or push waits for space, depending on the configuration.
+func (q *dispatchQueue) push(f func()) {
+	select {
+	case q.ch <- f:
+	default:
+		q.conn.metrics.AddCounter(MetricDispatchOverflows, 1, "server", q.conn.key, "queue", q.name)
+		if q.drop {
+			q.conn.log.Warn("Dispatch queue full, dropping packet", "key", q.conn.key, "queue", q.name)
+			return
+		}
+		q.ch <- f
+	}
+	q.conn.metrics.SetGauge(MetricDispatchQueueDepth, float64(len(q.ch)), "server", q.conn.key, "queue", q.name)
+}
+
+func (q *dispatchQueue) work() {
+	defer q.wg.Done()
+	for f := range q.ch {
+		q.conn.metrics.SetGauge(MetricDispatchQueueDepth, float64(len(q.ch)), "server", q.conn.key, "queue", q.name)
+		f()
+	}
+}
+
+// close stops the workers once the packets already queued have been handled. push must not be called after.
+func (q *dispatchQueue) close() {
+	close(q.ch)
+}
@@ -0,0 +1,260 @@
+package gtipc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gameparrot/gtipc/ipcprotocol/rak2user"
+)
+
+// ErrDraining is returned when dialing a PM server that is being drained.
+var ErrDraining = errors.New("pocketmine server is draining")
+
+// errNotDraining is returned when cancelling the drain of a PM server that is not being drained.
+var errNotDraining = errors.New("pocketmine server is not draining")
+
+// drainNoticePrefix starts custom packets that tell a PM server it is being drained. It is followed by the
+// deadline as big endian unix milliseconds, or 0 if there is no deadline.
+var drainNoticePrefix = []byte("gtipc:drain\x00")
+
+// drainCancelNotice is the custom packet that tells a PM server that it is no longer being drained.
+var drainCancelNotice = []byte("gtipc:drain-cancel\x00")
+
+// DrainCompleteFunc is called when a PM server has finished draining. Forced is the amount of sessions that were
+// still open at the deadline and were closed by the proxy. Close and Shutdown wait for it to return, so it must not
+// call them.
+type DrainCompleteFunc func(serverKey string, forced int)
+
+// DrainRedirectFunc returns the key of the PM server to open sessions on instead of a server that is draining. No
+// session is opened if it returns false.
+type DrainRedirectFunc func(serverKey string) (string, bool)
+
+// EncodeDrainNotice returns the payload of the custom packet sent to a PM server when it starts draining, holding the
+// deadline after which its remaining sessions are closed. A zero deadline, meaning that sessions are not closed, is
+// encoded as 0.
+func EncodeDrainNotice(deadline time.Time) []byte {
+	var ms int64
+	if !deadline.IsZero() {
+		ms = deadline.UnixMilli()
+	}
+	return binary.BigEndian.AppendUint64(bytes.Clone(drainNoticePrefix), uint64(ms))
+}
+
+// DecodeDrainNotice decodes the payload of a custom packet encoded with EncodeDrainNotice. False is returned if the
+// payload is not a drain notice. The deadline is zero if the server has no deadline.
+func DecodeDrainNotice(b []byte) (deadline time.Time, ok bool) {
+	rest, ok := bytes.CutPrefix(b, drainNoticePrefix)
+	if !ok || len(rest) != 8 {
+		return time.Time{}, false
+	}
+	if ms := int64(binary.BigEndian.Uint64(rest)); ms != 0 {
+		deadline = time.UnixMilli(ms)
+	}
+	return deadline, true
+}
+
+// EncodeDrainCancel returns the payload of the custom packet sent to a PM server when its drain is cancelled.
+func EncodeDrainCancel() []byte {
+	return bytes.Clone(drainCancelNotice)
+}
+
+// DecodeDrainCancel reports if the payload of a custom packet was encoded with EncodeDrainCancel.
+func DecodeDrainCancel(b []byte) bool {
+	return bytes.Equal(b, drainCancelNotice)
+}
+
+// drainState is the state of a Conn that is being drained.
+type drainState struct {
+	deadline time.Time
+	timer    *time.Timer
+	forced   atomic.Int64
+
+	once       sync.Once
+	done       chan struct{}
+	onComplete func(forced int)
+	// start starts the goroutine that onComplete is called on.
+	start func(f func())
+}
+
+// complete stops the deadline timer and calls the completion callback on its own goroutine, once.
+func (d *drainState) complete() {
+	d.once.Do(func() {
+		if d.timer != nil {
+			d.timer.Stop()
+		}
+		close(d.done)
+		d.start(func() { d.onComplete(int(d.forced.Load())) })
+	})
+}
+
+// cancel stops the deadline timer and prevents the completion callback from being called, unless it already was.
+func (d *drainState) cancel() {
+	d.once.Do(func() {
+		if d.timer != nil {
+			d.timer.Stop()
+		}
+	})
+}
+
+// Drain marks the PM server with the key as draining. New sessions are no longer opened on it. If
+// IpcOptions.NotifyDrain is set, the PM server itself is sent a custom packet encoded with EncodeDrainNotice; the
+// sessions on it are not notified, so telling players is left to the server. Sessions still open when the timeout
+// passes are closed with DisconnectReasonServerShutdown. IpcOptions.DrainComplete is called once no sessions remain.
+// The server stays draining until it disconnects or CancelDrain is called.
+func (l *IpcServer) Drain(key string, timeout time.Duration) error {
+	conn, ok := l.GetConn(key)
+	if !ok {
+		return errNotFound
+	}
+	if conn.draining() {
+		return ErrDraining
+	}
+	l.drain(conn, time.Now().Add(timeout), l.opts.NotifyDrain)
+	return nil
+}
+
+// drain starts draining a conn, or returns the state of the drain if it is already draining. Sessions are closed
+// at the deadline, unless it is zero. If notify is set, the server is sent a drain notice.
+func (l *IpcServer) drain(conn *Conn, deadline time.Time, notify bool) *drainState {
+	d := &drainState{deadline: deadline, done: make(chan struct{}), start: l.goDrainComplete, onComplete: func(forced int) {
+		l.opts.Log.Info("Server drained", "key", conn.key, "forced", forced)
+		if l.opts.DrainComplete != nil {
+			l.opts.DrainComplete(conn.key, forced)
+		}
+	}}
+
+	conn.sessionsMut.Lock()
+	if conn.drain != nil {
+		d = conn.drain
+		conn.sessionsMut.Unlock()
+		return d
+	}
+	conn.drain = d
+	empty := len(conn.sessions) == 0
+	if !empty && !deadline.IsZero() {
+		d.timer = time.AfterFunc(time.Until(deadline), func() { conn.forceDrain(d) })
+	}
+	conn.sessionsMut.Unlock()
+
+	l.opts.Log.Info("Draining server", "key", conn.key, "deadline", deadline)
+	if notify {
+		if err := conn.WriteCustomPacket(EncodeDrainNotice(deadline)); err != nil {
+			l.opts.Log.Error("Failed to notify server of drain", "key", conn.key, "err", err.Error())
+		}
+	}
+	if empty {
+		d.complete()
+	}
+	return d
+}
+
+// CancelDrain stops draining the PM server with the key, so that sessions are opened on it again. Sessions are no
+// longer closed at the deadline of the drain, and IpcOptions.DrainComplete is not called if it has not been yet. If
+// IpcOptions.NotifyDrain is set, the PM server is sent a custom packet encoded with EncodeDrainCancel. Drains started
+// by Shutdown can't be cancelled.
+func (l *IpcServer) CancelDrain(key string) error {
+	conn, ok := l.GetConn(key)
+	if !ok {
+		return errNotFound
+	}
+	conn.sessionsMut.Lock()
+	// Shutdown cancels the context before it drains the conns under sessionsMut, so a drain found here with the
+	// context not yet cancelled is not one that Shutdown waits for.
+	if l.ctx.Err() != nil {
+		conn.sessionsMut.Unlock()
+		return net.ErrClosed
+	}
+	d := conn.drain
+	if d == nil {
+		conn.sessionsMut.Unlock()
+		return errNotDraining
+	}
+	conn.drain = nil
+	d.cancel()
+	conn.sessionsMut.Unlock()
+
+	l.opts.Log.Info("Drain cancelled", "key", conn.key)
+	if l.opts.NotifyDrain {
+		if err := conn.WriteCustomPacket(EncodeDrainCancel()); err != nil {
+			l.opts.Log.Error("Failed to notify server of cancelled drain", "key", conn.key, "err", err.Error())
+		}
+	}
+	return nil
+}
+
+// goDrainComplete calls f on its own goroutine. Shutdown waits for the goroutines started until waitDrainComplete
+// is called.
+func (l *IpcServer) goDrainComplete(f func()) {
+	l.drainMu.Lock()
+	defer l.drainMu.Unlock()
+	if l.drainWaited {
+		go f()
+		return
+	}
+	l.drainWg.Add(1)
+	go func() {
+		defer l.drainWg.Done()
+		f()
+	}()
+}
+
+// waitDrainComplete waits for the drain completion callbacks started so far. Callbacks started after it is called
+// are not waited for. The callbacks are tracked apart from l.wg, as drains may complete while it is waited for.
+func (l *IpcServer) waitDrainComplete() {
+	l.drainMu.Lock()
+	l.drainWaited = true
+	l.drainMu.Unlock()
+	l.drainWg.Wait()
+}
+
+// IsDraining reports if the PM server with the key is connected and being drained.
+func (l *IpcServer) IsDraining(key string) bool {
+	conn, ok := l.GetConn(key)
+	return ok && conn.draining()
+}
+
+// draining reports if the conn is being drained.
+func (c *Conn) draining() bool {
+	c.sessionsMut.Lock()
+	defer c.sessionsMut.Unlock()
+	return c.drain != nil
+}
+
+// forceDrain closes all sessions of a draining conn once the deadline of the drain d has passed, unless d was
+// cancelled.
+func (c *Conn) forceDrain(d *drainState) {
+	c.sessionsMut.Lock()
+	if c.drain != d {
+		c.sessionsMut.Unlock()
+		return
+	}
+	d.forced.Store(int64(len(c.sessions)))
+	c.sessionsMut.Unlock()
+	c.log.Info("Drain deadline passed, closing sessions", "key", c.key)
+	c.closeSessions(rak2user.DisconnectReasonServerShutdown)
+}
+
+// closeSessions closes all sessions of the conn with the reason passed.
+func (c *Conn) closeSessions(reason byte) {
+	c.sessionsMut.Lock()
+	sessions := make([]*clientConn, 0, len(c.sessions))
+	for _, s := range c.sessions {
+		sessions = append(sessions, s)
+	}
+	c.sessionsMut.Unlock()
+	for _, s := range sessions {
+		s.closeWithReason(reason)
+	}
+}
+
+// sessionRemoved completes the drain of the conn if its last session was removed. c.sessionsMut must be held.
+func (c *Conn) sessionRemoved() {
+	if c.drain != nil && len(c.sessions) == 0 {
+		c.drain.complete()
+	}
+}
@@ -0,0 +1,139 @@
+package gtipc_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gameparrot/gtipc"
+	"github.com/gameparrot/gtipc/gtipctest"
+)
+
+// drainServer starts an IpcServer that notifies servers of drains and reports completed drains on the channel
+// returned.
+func drainServer(t *testing.T) (*gtipc.IpcServer, string, chan string) {
+	t.Helper()
+	completed := make(chan string, 16)
+	sock := filepath.Join(t.TempDir(), "gtipc.sock")
+	s, err := gtipc.NewIPCServer(sock, &gtipc.IpcOptions{Log: discardLog, NotifyDrain: true, DrainComplete: func(key string, _ int) {
+		completed <- key
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, sock, completed
+}
+
+// connectFake connects a fake PM server with the key and waits until the IpcServer has registered it.
+func connectFake(t *testing.T, ctx context.Context, s *gtipc.IpcServer, sock, key string) (*gtipctest.Server, *gtipc.Conn) {
+	t.Helper()
+	fake, err := gtipctest.Connect(sock, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fake.Close() })
+	for {
+		if conn, ok := s.GetConn(key); ok {
+			return fake, conn
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("server %s did not connect", key)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCancelDrain(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s, sock, completed := drainServer(t)
+	fake, conn := connectFake(t, ctx, s, sock, "lobby")
+
+	c, err := conn.OpenSession("203.0.113.7:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := c.(gtipc.Session)
+	if err := s.CancelDrain("lobby"); err == nil {
+		t.Fatal("cancelled the drain of a server that is not draining")
+	}
+	if err := s.Drain("lobby", 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	b, err := fake.ReadCustomPacket(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gtipc.DecodeDrainNotice(b); !ok {
+		t.Fatalf("server received custom packet %q, want a drain notice", b)
+	}
+	if _, err := conn.OpenSession("203.0.113.7:19132"); !errors.Is(err, gtipc.ErrDraining) {
+		t.Fatalf("opening a session on a draining server returned %v, want ErrDraining", err)
+	}
+
+	if err := s.CancelDrain("lobby"); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := fake.ReadCustomPacket(ctx); err != nil || !gtipc.DecodeDrainCancel(b) {
+		t.Fatalf("server received custom packet %q (%v), want a drain cancel notice", b, err)
+	}
+	if s.IsDraining("lobby") {
+		t.Fatal("server is still draining after cancelling the drain")
+	}
+	// The session outlives the deadline of the cancelled drain, and closing it does not complete the drain.
+	time.Sleep(200 * time.Millisecond)
+	if _, ok := conn.Session(session.ID()); !ok {
+		t.Fatal("session was closed at the deadline of the cancelled drain")
+	}
+	if _, err := conn.OpenSession("203.0.113.7:19132"); err != nil {
+		t.Fatalf("opening a session after cancelling the drain: %v", err)
+	}
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case key := <-completed:
+		t.Fatalf("drain of %s completed after it was cancelled", key)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// TestShutdownDrainComplete shuts down a server while the sessions of its backends are closed, completing their
+// drains. Every DrainComplete callback must have returned once Shutdown returns.
+func TestShutdownDrainComplete(t *testing.T) {
+	for range 20 {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		s, sock, completed := drainServer(t)
+		var conns []*gtipc.Conn
+		for _, key := range []string{"a", "b", "c", "d"} {
+			_, conn := connectFake(t, ctx, s, sock, key)
+			if _, err := conn.OpenSession("203.0.113.7:19132"); err != nil {
+				t.Fatal(err)
+			}
+			conns = append(conns, conn)
+		}
+
+		var wg sync.WaitGroup
+		for _, conn := range conns {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for _, session := range conn.Sessions() {
+					_ = session.Close()
+				}
+			}()
+		}
+		if err := s.Shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(completed); n != len(conns) {
+			t.Fatalf("%d drains completed when Shutdown returned, want %d", n, len(conns))
+		}
+		wg.Wait()
+		cancel()
+	}
+}
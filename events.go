@@ -0,0 +1,131 @@
+package gtipc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gameparrot/gtipc/internal"
+)
+
+// EventHandler handles lifecycle events of servers and sessions. Methods are called on a separate goroutine in the
+// order the events occurred, so a slow handler does not stall reading packets from servers. Up to 16384 events are
+// queued for a handler that falls behind. Events beyond that are dropped and counted in MetricEventsDropped.
+type EventHandler interface {
+	// OnServerConnect is called when a PM server connects
+	OnServerConnect(serverKey string)
+	// OnServerDisconnect is called when a PM server disconnects
+	OnServerDisconnect(serverKey string)
+	// OnPongUpdate is called when a PM server updates its pong data
+	OnPongUpdate(serverKey string, pong []byte)
+	// OnSessionOpen is called when a session is opened on a PM server
+	OnSessionOpen(serverKey string, sessionID int32, clientAddr string)
+	// OnSessionClose is called when a session is closed. The reason is one of the rak2user.DisconnectReason constants
+	OnSessionClose(serverKey string, sessionID int32, reason byte)
+	// OnBlock is called when a PM server blocks an IP address
+	OnBlock(serverKey string, addr net.IP, duration time.Duration)
+	// OnUnblock is called when a PM server unblocks an IP address
+	OnUnblock(serverKey string, addr net.IP)
+	// OnTransferRequested is called when a PM server has moved one of its sessions to another server. Only sessions
+	// of a ProxySession can be transferred: it has already switched the player over to the new session and closed the
+	// session on the old server
+	OnTransferRequested(req TransferRequest)
+}
+
+// NopEventHandler is an EventHandler that does nothing. It may be embedded to implement only some of the methods.
+type NopEventHandler struct{}
+
+func (NopEventHandler) OnServerConnect(string)                {}
+func (NopEventHandler) OnServerDisconnect(string)             {}
+func (NopEventHandler) OnPongUpdate(string, []byte)           {}
+func (NopEventHandler) OnSessionOpen(string, int32, string)   {}
+func (NopEventHandler) OnSessionClose(string, int32, byte)    {}
+func (NopEventHandler) OnBlock(string, net.IP, time.Duration) {}
+func (NopEventHandler) OnUnblock(string, net.IP)              {}
+func (NopEventHandler) OnTransferRequested(TransferRequest)   {}
+
+// eventDispatcher calls the methods of an EventHandler on its own goroutine.
+type eventDispatcher struct {
+	h EventHandler
+	m Metrics
+
+	// sendMu guards queue, which only supports a single sender, and closed.
+	sendMu sync.Mutex
+	queue  *internal.ElasticChan[func(h EventHandler)]
+	closed bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newEventDispatcher(h EventHandler, m Metrics) *eventDispatcher {
+	d := &eventDispatcher{h: h, m: m}
+	if h == nil {
+		return d
+	}
+	d.queue = internal.Chan[func(h EventHandler)](16, 16384)
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	d.done = make(chan struct{})
+	go d.run()
+	return d
+}
+
+// dispatch queues f to be called with the event handler. It does nothing if no event handler is set or if the
+// dispatcher is closed. If the queue is full, f is dropped so that the caller, often a read loop, is not stalled.
+func (d *eventDispatcher) dispatch(f func(h EventHandler)) {
+	if d.h == nil {
+		return
+	}
+	d.sendMu.Lock()
+	if d.closed {
+		d.sendMu.Unlock()
+		return
+	}
+	ok := d.queue.TrySend(f)
+	d.sendMu.Unlock()
+	if !ok {
+		d.m.AddCounter(MetricEventsDropped, 1)
+		return
+	}
+	d.m.SetGauge(MetricEventQueueDepth, float64(d.queue.Len()))
+}
+
+// run calls the queued functions until the nil function queued by close is received, or until the dispatcher is
+// cancelled.
+func (d *eventDispatcher) run() {
+	defer close(d.done)
+	for {
+		f, ok := d.queue.Recv(d.ctx)
+		if !ok || f == nil {
+			return
+		}
+		d.m.SetGauge(MetricEventQueueDepth, float64(d.queue.Len()))
+		f(d.h)
+	}
+}
+
+// close stops accepting events and waits for the events queued before to be handled. If ctx is done first, the
+// remaining events are dropped and ctx.Err() is returned. It must not be called from an event handler.
+func (d *eventDispatcher) close(ctx context.Context) error {
+	if d.h == nil {
+		return nil
+	}
+	defer d.cancel()
+	d.sendMu.Lock()
+	first := !d.closed
+	d.closed = true
+	d.sendMu.Unlock()
+	// Nothing is sent after closed is set, so the nil function may be sent without holding sendMu. Waiting for room
+	// in a full queue is bound by ctx too.
+	if first && !d.queue.SendContext(ctx, nil) {
+		return ctx.Err()
+	}
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,85 @@
+package gtipc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingHandler is an EventHandler whose OnServerConnect blocks until release is closed.
+type blockingHandler struct {
+	NopEventHandler
+	started chan struct{}
+	release chan struct{}
+	handled chan string
+}
+
+func (h blockingHandler) OnServerConnect(key string) {
+	if key == "block" {
+		close(h.started)
+		<-h.release
+		return
+	}
+	h.handled <- key
+}
+
+func TestEventDispatcherFull(t *testing.T) {
+	h := blockingHandler{started: make(chan struct{}), release: make(chan struct{}), handled: make(chan string, 20000)}
+	m := NewPrometheusMetrics()
+	d := newEventDispatcher(h, m)
+
+	d.dispatch(func(h EventHandler) { h.OnServerConnect("block") })
+	<-h.started
+	// With the handler stuck, the queue fills up and further events are dropped instead of blocking dispatch.
+	for range 16384 + 10 {
+		d.dispatch(func(h EventHandler) { h.OnServerConnect("queued") })
+	}
+	var b strings.Builder
+	if err := m.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(b.String(), MetricEventsDropped+" 10\n") {
+		t.Fatalf("expected 10 dropped events, got metrics:\n%s", b.String())
+	}
+
+	// The queue is full, so close can't queue its nil function and must give up once ctx is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := d.close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("close returned %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("close took %v with a 50ms context", elapsed)
+	}
+	close(h.release)
+	select {
+	case <-d.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dispatcher did not stop after close")
+	}
+}
+
+func TestEventDispatcherClose(t *testing.T) {
+	h := blockingHandler{started: make(chan struct{}), release: make(chan struct{}), handled: make(chan string, 100)}
+	d := newEventDispatcher(h, NopMetrics{})
+	for range 100 {
+		d.dispatch(func(h EventHandler) { h.OnServerConnect("queued") })
+	}
+	// Events queued before close are handled before it returns, and events after it are ignored.
+	if err := d.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(h.handled); n != 100 {
+		t.Fatalf("%d events handled before close returned, want 100", n)
+	}
+	d.dispatch(func(h EventHandler) { h.OnServerConnect("late") })
+	if err := d.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(h.handled); n != 100 {
+		t.Fatalf("%d events handled after close, want 100", n)
+	}
+}
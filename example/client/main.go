@@ -11,6 +11,7 @@ import (
 
 func main() {
 	ipc := gtipc.NewIpcClient(nil)
+	defer ipc.Close()
 	minecraft.RegisterNetwork("ipc", func(l *slog.Logger) minecraft.Network {
 		return ipc
 	})
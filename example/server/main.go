@@ -1,7 +1,7 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log/slog"
 
 	"github.com/gameparrot/gtipc"
@@ -15,10 +15,6 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
-	minecraft.RegisterNetwork("ipc", func(l *slog.Logger) minecraft.Network {
-		return ipc
-	})
-
 	list, err := minecraft.ListenConfig{
 		StatusProvider: gtipc.NewIpcStatusProvider("default", ipc),
 	}.Listen("raknetupstream", "0.0.0.0:19132")
@@ -30,53 +26,14 @@ func main() {
 		if err != nil {
 			continue
 		}
-		go handleConn(conn.(*minecraft.Conn), list)
+		go handleConn(conn.(*minecraft.Conn), list, ipc)
 	}
 }
 
-func handleConn(conn *minecraft.Conn, list *minecraft.Listener) {
-	dial, err := minecraft.Dialer{
-		KeepXBLIdentityData: true,
-		IdentityData:        conn.IdentityData(),
-		ClientData:          conn.ClientData(),
-	}.Dial("ipc", "default;"+conn.RemoteAddr().String())
-	if err != nil {
-		fmt.Println(err)
-		list.Disconnect(conn, err.Error())
-		return
-	}
-	if err := dial.DoSpawn(); err != nil {
+func handleConn(conn *minecraft.Conn, list *minecraft.Listener, ipc *gtipc.IpcServer) {
+	session := gtipc.NewProxySession(ipc, conn)
+	if err := session.Connect(context.Background(), "default"); err != nil {
+		slog.Error("Failed to connect player", "addr", conn.RemoteAddr().String(), "err", err.Error())
 		list.Disconnect(conn, err.Error())
-		return
 	}
-	if err := conn.StartGame(dial.GameData()); err != nil {
-		list.Disconnect(conn, err.Error())
-		return
-	}
-	go func() {
-		defer conn.Close()
-		defer dial.Close()
-		for {
-			pk, err := conn.ReadPacket()
-			if err != nil {
-				list.Disconnect(conn, err.Error())
-				fmt.Println(err)
-				return
-			}
-			dial.WritePacket(pk)
-		}
-	}()
-	go func() {
-		defer conn.Close()
-		defer dial.Close()
-		for {
-			pk, err := dial.ReadPacket()
-			if err != nil {
-				list.Disconnect(conn, err.Error())
-				fmt.Println(err)
-				return
-			}
-			conn.WritePacket(pk)
-		}
-	}()
 }
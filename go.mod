@@ -3,12 +3,12 @@ module github.com/gameparrot/gtipc
 go 1.23.6
 
 require (
+	github.com/go-gl/mathgl v1.1.0
 	github.com/sandertv/go-raknet v1.14.2
 	github.com/sandertv/gophertunnel v1.44.0
 )
 
 require (
-	github.com/go-gl/mathgl v1.1.0 // indirect
 	github.com/go-jose/go-jose/v4 v4.0.4 // indirect
 	github.com/golang/snappy v0.0.4 // indirect
 	github.com/google/uuid v1.6.0 // indirect
@@ -0,0 +1,106 @@
+package gtipctest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/gameparrot/gtipc/internal"
+	"github.com/gameparrot/gtipc/ipcprotocol"
+	"github.com/gameparrot/gtipc/ipcprotocol/versions"
+)
+
+// Listener is a fake PM server with the RakLib IPC module listening on a unix socket, which IpcClients connect to.
+// Every accepted connection is served by its own Server.
+type Listener struct {
+	l        net.Listener
+	path     string
+	protocol *ipcprotocol.Protocol
+
+	pongMu sync.Mutex
+	pong   []byte
+
+	conns *internal.ElasticChan[*Server]
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Listen starts listening for IpcClients on the socket path. Any file already at the path is removed.
+func Listen(socketPath string) (*Listener, error) {
+	return ListenProtocol(socketPath, versions.V1)
+}
+
+// ListenProtocol starts listening for IpcClients like Listen, speaking the protocol layout passed. IpcClients
+// connecting must be configured with the same layout in IpcOptions.Protocol.
+func ListenProtocol(socketPath string, p *ipcprotocol.Protocol) (*Listener, error) {
+	if _, err := os.Stat(socketPath); err == nil {
+		os.Remove(socketPath)
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	listener := &Listener{l: l, path: filepath.Clean(socketPath), protocol: p, conns: internal.Chan[*Server](4, 4096)}
+	listener.ctx, listener.cancel = context.WithCancel(context.Background())
+	go listener.acceptLoop()
+	return listener, nil
+}
+
+// SetPong sets the pong data sent to every connection accepted after the call.
+func (l *Listener) SetPong(pong []byte) {
+	l.pongMu.Lock()
+	l.pong = pong
+	l.pongMu.Unlock()
+}
+
+// Accept waits for an IpcClient to connect. The Server returned uses the socket path as its name, which is the key
+// the IpcClient knows it by.
+func (l *Listener) Accept(ctx context.Context) (*Server, error) {
+	ctx, cancel := mergeContext(ctx, l.ctx)
+	defer cancel()
+	s, ok := l.conns.Recv(ctx)
+	if !ok {
+		if l.ctx.Err() != nil {
+			return nil, net.ErrClosed
+		}
+		return nil, ctx.Err()
+	}
+	return s, nil
+}
+
+// Addr returns the socket path the listener listens on.
+func (l *Listener) Addr() string {
+	return l.path
+}
+
+// Close stops listening and removes the socket. Servers already accepted are not closed.
+func (l *Listener) Close() error {
+	l.cancel()
+	err := l.l.Close()
+	os.Remove(l.path)
+	return err
+}
+
+func (l *Listener) acceptLoop() {
+	for {
+		conn, err := l.l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		s := newServer(conn, l.path, l.protocol)
+		l.pongMu.Lock()
+		pong := l.pong
+		l.pongMu.Unlock()
+		if pong != nil {
+			_ = s.SetPong(pong)
+		}
+		l.conns.Send(s)
+	}
+}
@@ -0,0 +1,99 @@
+package gtipctest
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/sandertv/gophertunnel/minecraft"
+	"github.com/sandertv/gophertunnel/minecraft/protocol/packet"
+)
+
+// networkID is used to register a unique gophertunnel network for every listener.
+var networkID atomic.Int64
+
+// Listen returns a gophertunnel listener that accepts the sessions opened on the server as server-side
+// minecraft.Conns, which perform the Bedrock login sequence. Sessions accepted by the listener are not returned by
+// Server.Accept. The status of the listener is sent to the proxy as the pong data of the server. As proxies
+// usually dial without Xbox Live tokens, AuthenticationDisabled should be set in the config.
+func (s *Server) Listen(cfg minecraft.ListenConfig) (*minecraft.Listener, error) {
+	name := "gtipctest-" + strconv.FormatInt(networkID.Add(1), 10)
+	minecraft.RegisterNetwork(name, func(*slog.Logger) minecraft.Network {
+		return network{s: s}
+	})
+	return cfg.Listen(name, s.name)
+}
+
+// AcceptGame accepts the next connection on a listener returned by Server.Listen and spawns the player with the
+// game data passed, completing the login sequence.
+func AcceptGame(l *minecraft.Listener, data minecraft.GameData) (*minecraft.Conn, error) {
+	c, err := l.Accept()
+	if err != nil {
+		return nil, err
+	}
+	conn := c.(*minecraft.Conn)
+	if err := conn.StartGame(data); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// network is a gophertunnel network that listens for sessions opened on a fake PM server.
+type network struct {
+	s *Server
+}
+
+func (network) DialContext(context.Context, string) (net.Conn, error) {
+	return nil, errors.New("not supported")
+}
+
+func (network) PingContext(context.Context, string) ([]byte, error) {
+	return nil, errors.New("not supported")
+}
+
+func (n network) Listen(string) (minecraft.NetworkListener, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &listener{s: n.s, id: rand.Int63(), ctx: ctx, cancel: cancel}, nil
+}
+
+func (network) Compression(net.Conn) packet.Compression { return packet.FlateCompression }
+
+// listener is a minecraft.NetworkListener that accepts the sessions opened on a fake PM server.
+type listener struct {
+	s      *Server
+	id     int64
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	session, err := l.s.Accept(l.ctx)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (l *listener) Close() error {
+	l.cancel()
+	return nil
+}
+
+// Addr returns a UDP address, as gophertunnel writes the port of the listener to its pong data. The fake server has
+// no address of its own, so the default port of Bedrock servers is used.
+func (l *listener) Addr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4zero, Port: 19132}
+}
+
+func (l *listener) ID() int64 {
+	return l.id
+}
+
+func (l *listener) PongData(data []byte) {
+	_ = l.s.SetPong(data)
+}
@@ -0,0 +1,377 @@
+// Package gtipctest implements fake PM servers speaking the RakLib IPC protocol, for testing code built on gtipc
+// without running PocketMine.
+package gtipctest
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gameparrot/gtipc"
+	"github.com/gameparrot/gtipc/internal"
+	"github.com/gameparrot/gtipc/ipcprotocol"
+	"github.com/gameparrot/gtipc/ipcprotocol/rak2user"
+	"github.com/gameparrot/gtipc/ipcprotocol/user2rak"
+	"github.com/gameparrot/gtipc/ipcprotocol/versions"
+)
+
+// Server is a fake PM server. It decodes the rak2user packets sent by the proxy and lets tests send user2rak
+// packets back.
+type Server struct {
+	conn     net.Conn
+	name     string
+	protocol *ipcprotocol.Protocol
+
+	writeMu sync.Mutex
+
+	sessions   map[int32]*Session
+	sessionsMu sync.Mutex
+
+	opened        *internal.ElasticChan[*Session]
+	customPackets *internal.ElasticChan[[]byte]
+
+	statsMu      sync.Mutex
+	bandwidth    []rak2user.ReportBandwidthStats
+	pings        map[int32]time.Duration
+	statsChanged chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+// Connect connects a fake PM server to an IpcServer listening on the socket path, sending the name passed as its
+// key. Fake servers that IpcClients connect to are created with Listen.
+func Connect(socketPath, name string) (*Server, error) {
+	return connect(socketPath, name, []byte(name), versions.V1)
+}
+
+// ConnectProtocol connects a fake PM server like Connect, but selects the protocol layout passed in the handshake
+// and speaks it afterwards. The layout must be registered with the IpcServer's versions registry.
+func ConnectProtocol(socketPath, name string, p *ipcprotocol.Protocol) (*Server, error) {
+	return connect(socketPath, name, append([]byte(name), 0, p.Version()), p)
+}
+
+func connect(socketPath, name string, handshake []byte, p *ipcprotocol.Protocol) (*Server, error) {
+	if len(handshake) > 255 {
+		return nil, fmt.Errorf("name %q is too long", name)
+	}
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(append([]byte{byte(len(handshake))}, handshake...)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newServer(conn, name, p), nil
+}
+
+func newServer(conn net.Conn, name string, p *ipcprotocol.Protocol) *Server {
+	s := &Server{
+		conn:          conn,
+		name:          name,
+		protocol:      p,
+		sessions:      make(map[int32]*Session),
+		opened:        internal.Chan[*Session](4, 4096),
+		customPackets: internal.Chan[[]byte](4, 4096),
+		pings:         make(map[int32]time.Duration),
+		statsChanged:  make(chan struct{}),
+	}
+	s.ctx, s.cancel = context.WithCancelCause(context.Background())
+	go s.readLoop()
+	return s
+}
+
+// Name returns the name the server connected with.
+func (s *Server) Name() string {
+	return s.name
+}
+
+// SetPong sends the pong data of the server, as PM does with SetName.
+func (s *Server) SetPong(pong []byte) error {
+	return s.WritePacket(&user2rak.SetName{Name: pong})
+}
+
+// SendCustomPacket sends a custom packet to the proxy.
+func (s *Server) SendCustomPacket(b []byte) error {
+	return s.WritePacket(&user2rak.Encapsulated{SessionID: -1, UserPayload: b})
+}
+
+// RequestTransfer asks the proxy to move a session to the server with the target key, as a PM plugin does with
+// gtipc.EncodeTransferCommand.
+func (s *Server) RequestTransfer(sessionID int32, target string) error {
+	return s.SendCustomPacket(gtipc.EncodeTransferCommand(sessionID, target))
+}
+
+// BlockAddress asks the proxy to block an IP address for the duration passed, rounded down to seconds.
+func (s *Server) BlockAddress(addr string, timeout time.Duration) error {
+	return s.WritePacket(&user2rak.BlockAddress{Addr: addr, Timeout: uint32(timeout / time.Second)})
+}
+
+// UnblockAddress asks the proxy to unblock an IP address.
+func (s *Server) UnblockAddress(addr string) error {
+	return s.WritePacket(&user2rak.UnblockAddress{Addr: addr})
+}
+
+// Protocol returns the protocol layout the server speaks.
+func (s *Server) Protocol() *ipcprotocol.Protocol {
+	return s.protocol
+}
+
+// WritePacket writes a user2rak packet to the proxy. An error is returned if the protocol layout of the server does
+// not support the packet.
+func (s *Server) WritePacket(pk ipcprotocol.Packet) error {
+	frame, err := s.protocol.Encode(pk)
+	if err != nil {
+		return err
+	}
+	b := binary.BigEndian.AppendUint32(make([]byte, 0, len(frame)+4), uint32(len(frame)))
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err = s.conn.Write(append(b, frame...))
+	return err
+}
+
+// Accept waits for the proxy to open a session on the server.
+func (s *Server) Accept(ctx context.Context) (*Session, error) {
+	ctx, cancel := mergeContext(ctx, s.ctx)
+	defer cancel()
+	session, ok := s.opened.Recv(ctx)
+	if !ok {
+		return nil, s.ctxErr(ctx)
+	}
+	return session, nil
+}
+
+// ReadCustomPacket waits for the proxy to send a custom packet. Session metadata is returned by Session.Metadata
+// instead.
+func (s *Server) ReadCustomPacket(ctx context.Context) ([]byte, error) {
+	ctx, cancel := mergeContext(ctx, s.ctx)
+	defer cancel()
+	b, ok := s.customPackets.Recv(ctx)
+	if !ok {
+		return nil, s.ctxErr(ctx)
+	}
+	return b, nil
+}
+
+// Session returns the open session with the ID passed.
+func (s *Server) Session(id int32) (*Session, bool) {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	session, ok := s.sessions[id]
+	return session, ok
+}
+
+// Sessions returns all sessions that are currently open.
+func (s *Server) Sessions() []*Session {
+	s.sessionsMu.Lock()
+	defer s.sessionsMu.Unlock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// BandwidthReports returns all bandwidth stats reported by the proxy so far.
+func (s *Server) BandwidthReports() []rak2user.ReportBandwidthStats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return append([]rak2user.ReportBandwidthStats(nil), s.bandwidth...)
+}
+
+// Ping returns the last ping reported by the proxy for a session.
+func (s *Server) Ping(sessionID int32) (time.Duration, bool) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	ping, ok := s.pings[sessionID]
+	return ping, ok
+}
+
+// WaitBandwidthReport waits until the proxy has reported bandwidth stats at least n times and returns the last
+// report.
+func (s *Server) WaitBandwidthReport(ctx context.Context, n int) (rak2user.ReportBandwidthStats, error) {
+	var report rak2user.ReportBandwidthStats
+	err := s.waitStats(ctx, func() bool {
+		if len(s.bandwidth) < n || len(s.bandwidth) == 0 {
+			return false
+		}
+		report = s.bandwidth[len(s.bandwidth)-1]
+		return true
+	})
+	return report, err
+}
+
+// WaitPing waits until the proxy has reported a ping for a session and returns it.
+func (s *Server) WaitPing(ctx context.Context, sessionID int32) (time.Duration, error) {
+	var ping time.Duration
+	err := s.waitStats(ctx, func() bool {
+		var ok bool
+		ping, ok = s.pings[sessionID]
+		return ok
+	})
+	return ping, err
+}
+
+// waitStats waits until cond, called with statsMu held, returns true.
+func (s *Server) waitStats(ctx context.Context, cond func() bool) error {
+	for {
+		s.statsMu.Lock()
+		ok, changed := cond(), s.statsChanged
+		s.statsMu.Unlock()
+		if ok {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.ctx.Done():
+			return s.ctxErr(ctx)
+		}
+	}
+}
+
+// Serve calls handler on a new goroutine for every session opened on the server, until ctx is done or the server
+// is closed. It may be used to script the responses of the server.
+func (s *Server) Serve(ctx context.Context, handler func(session *Session)) error {
+	for {
+		session, err := s.Accept(ctx)
+		if err != nil {
+			return err
+		}
+		go handler(session)
+	}
+}
+
+// Done returns a channel that is closed once the connection to the proxy is closed.
+func (s *Server) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Err returns the error that closed the connection to the proxy, or nil if it is still open.
+func (s *Server) Err() error {
+	return context.Cause(s.ctx)
+}
+
+// Close closes the connection to the proxy, closing all sessions.
+func (s *Server) Close() error {
+	s.cancel(net.ErrClosed)
+	return s.conn.Close()
+}
+
+func (s *Server) readLoop() {
+	r := bufio.NewReader(s.conn)
+	pool := s.protocol.Rak2UserPool()
+	defer func() {
+		s.conn.Close()
+		s.sessionsMu.Lock()
+		for id, session := range s.sessions {
+			session.close(rak2user.DisconnectReasonServerShutdown)
+			delete(s.sessions, id)
+		}
+		s.sessionsMu.Unlock()
+	}()
+	for {
+		var l [4]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			s.cancel(err)
+			return
+		}
+		frame := make([]byte, binary.BigEndian.Uint32(l[:]))
+		if _, err := io.ReadFull(r, frame); err != nil {
+			s.cancel(err)
+			return
+		}
+		pk, err := ipcprotocol.Decode(pool, frame)
+		if err != nil {
+			s.cancel(err)
+			return
+		}
+		s.handlePacket(pk)
+	}
+}
+
+func (s *Server) handlePacket(pk ipcprotocol.Packet) {
+	switch pk := pk.(type) {
+	case *rak2user.OpenSession:
+		session := newSession(s, pk)
+		s.sessionsMu.Lock()
+		s.sessions[pk.SessionID] = session
+		s.sessionsMu.Unlock()
+		s.opened.Send(session)
+	case *rak2user.Encapsulated:
+		if pk.SessionID == -1 {
+			if id, metadata, ok := gtipc.DecodeSessionMetadata(pk.UserPayload); ok {
+				if session, ok := s.Session(id); ok {
+					session.setMetadata(metadata)
+				}
+				return
+			}
+			s.customPackets.Send(pk.UserPayload)
+			return
+		}
+		if session, ok := s.Session(pk.SessionID); ok {
+			session.payloads.Send(pk.UserPayload)
+		}
+	case *rak2user.CloseSession:
+		s.sessionsMu.Lock()
+		session, ok := s.sessions[pk.SessionID]
+		delete(s.sessions, pk.SessionID)
+		s.sessionsMu.Unlock()
+		if ok {
+			session.close(pk.Reason)
+		}
+	case *rak2user.AckNotification:
+		if session, ok := s.Session(pk.SessionID); ok {
+			session.ack(pk.ACK)
+		}
+	case *rak2user.ReportBandwidthStats:
+		s.statsMu.Lock()
+		s.bandwidth = append(s.bandwidth, *pk)
+		s.notifyStats()
+		s.statsMu.Unlock()
+	case *rak2user.ReportPing:
+		s.statsMu.Lock()
+		s.pings[pk.SessionID] = time.Duration(pk.Ping) * time.Millisecond
+		s.notifyStats()
+		s.statsMu.Unlock()
+	}
+}
+
+// notifyStats wakes up all goroutines waiting in waitStats. statsMu must be held.
+func (s *Server) notifyStats() {
+	close(s.statsChanged)
+	s.statsChanged = make(chan struct{})
+}
+
+// removeSession removes a session closed by the server itself.
+func (s *Server) removeSession(id int32) {
+	s.sessionsMu.Lock()
+	delete(s.sessions, id)
+	s.sessionsMu.Unlock()
+}
+
+// ctxErr returns the error to return when ctx, merged with the server context, is done.
+func (s *Server) ctxErr(ctx context.Context) error {
+	if s.ctx.Err() != nil {
+		return fmt.Errorf("server closed: %w", context.Cause(s.ctx))
+	}
+	return ctx.Err()
+}
+
+// mergeContext returns a context that is done once either of the contexts passed is done.
+func mergeContext(a, b context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(a)
+	stop := context.AfterFunc(b, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
@@ -0,0 +1,192 @@
+package gtipctest
+
+import (
+	"context"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gameparrot/gtipc/internal"
+	"github.com/gameparrot/gtipc/ipcprotocol/rak2user"
+	"github.com/gameparrot/gtipc/ipcprotocol/user2rak"
+)
+
+// reliabilityReliableOrdered is the RakNet reliability used for payloads written to sessions.
+const reliabilityReliableOrdered = 3
+
+// Session is a session that the proxy opened on a fake PM server. It implements net.Conn: Read returns the payloads
+// sent by the proxy and Write sends payloads back.
+type Session struct {
+	// ID is the session ID assigned by the proxy
+	ID int32
+	// Addr and Port are the client address sent in OpenSession
+	Addr net.IP
+	Port uint16
+	// ClientID is the client ID sent in OpenSession
+	ClientID int64
+
+	server   *Server
+	payloads *internal.ElasticChan[[]byte]
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	once   sync.Once
+	reason byte
+
+	acksMu sync.Mutex
+	acks   []int32
+
+	metadata     map[string]string
+	metadataSet  chan struct{}
+	metadataOnce sync.Once
+}
+
+func newSession(s *Server, pk *rak2user.OpenSession) *Session {
+	session := &Session{ID: pk.SessionID, Addr: pk.Addr, Port: pk.Port, ClientID: pk.ClientID, server: s, payloads: internal.Chan[[]byte](4, 4096), metadataSet: make(chan struct{})}
+	session.ctx, session.cancel = context.WithCancel(context.Background())
+	return session
+}
+
+// ReadPacket returns the next payload sent by the proxy.
+func (s *Session) ReadPacket() ([]byte, error) {
+	b, ok := s.payloads.Recv(s.ctx)
+	if !ok {
+		return nil, net.ErrClosed
+	}
+	return b, nil
+}
+
+// Read ...
+func (s *Session) Read(b []byte) (int, error) {
+	pk, err := s.ReadPacket()
+	if err != nil {
+		return 0, err
+	}
+	return copy(b, pk), nil
+}
+
+// Write sends a payload to the proxy.
+func (s *Session) Write(b []byte) (int, error) {
+	if s.ctx.Err() != nil {
+		return 0, net.ErrClosed
+	}
+	err := s.server.WritePacket(&user2rak.Encapsulated{SessionID: s.ID, Reliability: reliabilityReliableOrdered, UserPayload: b})
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// WriteWithAck sends a payload to the proxy, requesting an ack notification with the ID passed. Received acks are
+// returned by Acks.
+func (s *Session) WriteWithAck(b []byte, ack int32) error {
+	if s.ctx.Err() != nil {
+		return net.ErrClosed
+	}
+	return s.server.WritePacket(&user2rak.Encapsulated{SessionID: s.ID, Flags: 1 << 0, Reliability: reliabilityReliableOrdered, Ack: ack, UserPayload: b})
+}
+
+// Acks returns the IDs of all ack notifications received for the session.
+func (s *Session) Acks() []int32 {
+	s.acksMu.Lock()
+	defer s.acksMu.Unlock()
+	return slices.Clone(s.acks)
+}
+
+func (s *Session) ack(id int32) {
+	s.acksMu.Lock()
+	s.acks = append(s.acks, id)
+	s.acksMu.Unlock()
+}
+
+// Metadata waits for the proxy to forward metadata for the session, as it does when the session is opened with
+// gtipc.DialSession and an XUID or metadata set.
+func (s *Session) Metadata(ctx context.Context) (map[string]string, error) {
+	select {
+	case <-s.metadataSet:
+		return maps.Clone(s.metadata), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (s *Session) setMetadata(metadata map[string]string) {
+	s.metadataOnce.Do(func() {
+		s.metadata = metadata
+		close(s.metadataSet)
+	})
+}
+
+// Close closes the session from the server side, sending CloseSession to the proxy.
+func (s *Session) Close() error {
+	if s.ctx.Err() != nil {
+		return nil
+	}
+	s.close(rak2user.DisconnectReasonServerDisconnect)
+	s.server.removeSession(s.ID)
+	return s.server.WritePacket(&user2rak.CloseSession{SessionID: s.ID})
+}
+
+func (s *Session) close(reason byte) {
+	s.once.Do(func() {
+		s.reason = reason
+		s.cancel()
+	})
+}
+
+// Done returns a channel that is closed once the session is closed.
+func (s *Session) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// CloseReason returns the reason the session was closed with, which is one of the rak2user.DisconnectReason
+// constants. It returns false if the session is still open.
+func (s *Session) CloseReason() (byte, bool) {
+	select {
+	case <-s.ctx.Done():
+		return s.reason, true
+	default:
+		return 0, false
+	}
+}
+
+// LocalAddr ...
+func (s *Session) LocalAddr() net.Addr {
+	return sessionAddr{server: s.server.name, id: s.ID}
+}
+
+// RemoteAddr returns the client address sent in OpenSession.
+func (s *Session) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: s.Addr, Port: int(s.Port)}
+}
+
+// SetDeadline is a no-op.
+func (s *Session) SetDeadline(time.Time) error {
+	return nil
+}
+
+// SetReadDeadline is a no-op.
+func (s *Session) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline is a no-op.
+func (s *Session) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+type sessionAddr struct {
+	server string
+	id     int32
+}
+
+func (a sessionAddr) Network() string {
+	return "ipc"
+}
+
+func (a sessionAddr) String() string {
+	return a.server + ":" + strconv.Itoa(int(a.id))
+}
@@ -41,20 +41,84 @@ func (c *ElasticChan[T]) Recv(ctx context.Context) (val T, ok bool) {
 	}
 }
 
-// Send sends a value to the channel. Send never blocks, because if the maximum
-// capacity of the underlying channel is reached, a larger one is created.
+// TryRecv reads a value from the channel if one is available. It never
+// blocks and returns ok = false if the channel is empty.
+func (c *ElasticChan[T]) TryRecv() (val T, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	select {
+	case val = <-c.ch:
+		if c.len.Add(-1) < 0 {
+			panic("unreachable")
+		}
+		return val, true
+	default:
+		return val, false
+	}
+}
+
+// Len returns the number of values currently in the channel.
+func (c *ElasticChan[T]) Len() int {
+	return int(c.len.Load())
+}
+
+// Send sends a value to the channel. Send only blocks once the channel holds
+// its maximum amount of values, because until then a larger channel is
+// created if the capacity of the underlying channel is reached.
 func (c *ElasticChan[T]) Send(val T) {
-	if ccap := int64(cap(c.ch)); c.len.Add(1) >= ccap && ccap < c.lim {
-		// This check happens outside a lock, meaning in the meantime, a call to
-		// Recv could cause the length to decrease, technically meaning growing
-		// is then unnecessary. That isn't a major issue though, as in most
-		// cases growing would still be necessary later.
+	if c.reserve() {
 		c.growSend(val)
 		return
 	}
 	c.ch <- val
 }
 
+// SendContext sends a value to the channel like Send. If the channel holds its
+// maximum amount of values and ctx is canceled before a value is received,
+// the value is not sent and SendContext returns false.
+func (c *ElasticChan[T]) SendContext(ctx context.Context, val T) bool {
+	if c.reserve() {
+		c.growSend(val)
+		return true
+	}
+	select {
+	case c.ch <- val:
+		return true
+	case <-ctx.Done():
+		c.len.Add(-1)
+		return false
+	}
+}
+
+// TrySend sends a value to the channel like Send, but never blocks. If the
+// channel holds its maximum amount of values, the value is not sent and
+// TrySend returns false.
+func (c *ElasticChan[T]) TrySend(val T) bool {
+	if c.reserve() {
+		c.growSend(val)
+		return true
+	}
+	select {
+	case c.ch <- val:
+		return true
+	default:
+		c.len.Add(-1)
+		return false
+	}
+}
+
+// reserve counts a value about to be sent and reports if the channel must grow
+// before sending it.
+func (c *ElasticChan[T]) reserve() bool {
+	// This check happens outside a lock, meaning in the meantime, a call to
+	// Recv could cause the length to decrease, technically meaning growing
+	// is then unnecessary. That isn't a major issue though, as in most
+	// cases growing would still be necessary later.
+	ccap := int64(cap(c.ch))
+	return c.len.Add(1) >= ccap && ccap < c.lim
+}
+
 // growSend grows the channel to double the capacity, copying all values
 // currently in the channel, and sends the value to the new channel.
 func (c *ElasticChan[T]) growSend(val T) {
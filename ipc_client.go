@@ -3,7 +3,6 @@ package gtipc
 import (
 	"context"
 	"errors"
-	"log/slog"
 	"net"
 	"path/filepath"
 	"sync"
@@ -18,17 +17,21 @@ type IpcClient struct {
 	opts    *IpcOptions
 	conns   map[string]*Conn
 	connsMu sync.RWMutex
+	closed  bool
+	wg      sync.WaitGroup
+
+	closeOnce sync.Once
+
+	events *eventDispatcher
 }
 
 // NewIpcClient returns a new IPC client
 func NewIpcClient(opts *IpcOptions) *IpcClient {
-	if opts == nil {
-		opts = &IpcOptions{}
-	}
-	if opts.Log == nil {
-		opts.Log = slog.Default()
+	opts = opts.withDefaults()
+	if opts.Upstream != nil {
+		opts.Upstream.SetMetrics(opts.Metrics)
 	}
-	return &IpcClient{opts: opts, conns: make(map[string]*Conn)}
+	return &IpcClient{opts: opts, conns: make(map[string]*Conn), events: newEventDispatcher(opts.EventHandler, opts.Metrics)}
 }
 
 func (c *IpcClient) openConn(path string) (*Conn, error) {
@@ -36,16 +39,25 @@ func (c *IpcClient) openConn(path string) (*Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	conn := NewConn(c.opts.Log, unixConn, path, c)
+	conn := NewConn(c.opts.Log, unixConn, path, c, RoleClient)
+	c.connsMu.Lock()
+	if c.closed {
+		c.connsMu.Unlock()
+		unixConn.Close()
+		return nil, net.ErrClosed
+	}
+	c.conns[path] = conn
+	c.wg.Add(1)
+	c.connsMu.Unlock()
+	c.DispatchEvent(func(h EventHandler) { h.OnServerConnect(path) })
 	go func() {
+		defer c.wg.Done()
 		conn.ReadLoop()
 		c.connsMu.Lock()
 		delete(c.conns, path)
 		c.connsMu.Unlock()
+		c.DispatchEvent(func(h EventHandler) { h.OnServerDisconnect(path) })
 	}()
-	c.connsMu.Lock()
-	c.conns[path] = conn
-	c.connsMu.Unlock()
 	return conn, nil
 }
 
@@ -67,12 +79,33 @@ func (c *IpcClient) GetConn(key string) (*Conn, bool) {
 	return conn, ok
 }
 
+// Close closes the conns of the client and waits for their read loops to return, then waits for the events queued
+// to be handled. Conns are no longer opened after Close. Close may be called multiple times, but not from an event
+// handler.
+func (c *IpcClient) Close() error {
+	c.closeOnce.Do(func() {
+		c.connsMu.Lock()
+		c.closed = true
+		conns := make([]*Conn, 0, len(c.conns))
+		for _, conn := range c.conns {
+			conns = append(conns, conn)
+		}
+		c.connsMu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+		c.wg.Wait()
+		c.events.close(context.Background())
+	})
+	return nil
+}
+
 func (c *IpcClient) DialContext(ctx context.Context, address string) (net.Conn, error) {
 	conn, err := c.GetOrCreateConn(address)
 	if err != nil {
 		return nil, err
 	}
-	return conn.OpenSession("")
+	return conn.OpenSessionContext(ctx, "")
 }
 
 func (c *IpcClient) PingContext(ctx context.Context, address string) ([]byte, error) {
@@ -80,7 +113,7 @@ func (c *IpcClient) PingContext(ctx context.Context, address string) ([]byte, er
 	if err != nil {
 		return nil, err
 	}
-	return conn.pongData, nil
+	return conn.pingResponse(), nil
 }
 
 func (c *IpcClient) Listen(address string) (minecraft.NetworkListener, error) {
@@ -107,10 +140,21 @@ func (c *IpcClient) UnblockAddress(addr net.IP) {
 	}
 }
 
-func (c *IpcClient) handleCustomPacket(b []byte, serverKey string) {
+// HandleCustomPacket passes the packet to the custom packet handler in the options.
+func (c *IpcClient) HandleCustomPacket(b []byte, serverKey string) {
 	if c.opts.CustomPacketHandler != nil {
 		c.opts.CustomPacketHandler(b, serverKey)
 	}
 }
 
+// DispatchEvent queues f to be called with the event handler in the options.
+func (c *IpcClient) DispatchEvent(f func(h EventHandler)) {
+	c.events.dispatch(f)
+}
+
+// Options returns the options of the client.
+func (c *IpcClient) Options() *IpcOptions {
+	return c.opts
+}
+
 func (*IpcClient) Compression(net.Conn) packet.Compression { return packet.FlateCompression }
@@ -4,11 +4,18 @@ import (
 	"net"
 	"time"
 
+	"github.com/gameparrot/gtipc/ipcprotocol"
 	"github.com/sandertv/gophertunnel/minecraft"
 )
 
-type IpcHandler interface {
-	minecraft.Network
+// ConnHandler handles what a Conn reads from a PM server besides session payloads, and provides the options the
+// Conn uses. IpcServer and IpcClient implement it, but it may be implemented outside the package too, for example
+// by a routing layer wrapping several IpcServers or one that authenticates servers, which can then create its own
+// Conns with NewConn.
+type ConnHandler interface {
+	// HandleCustomPacket is called with the payload of every custom packet sent by the server with the key. It is
+	// called from the custom packet queue of the conn.
+	HandleCustomPacket(b []byte, serverKey string)
 
 	// BlockAddress blocks an IP address from accessing the server
 	BlockAddress(addr net.IP, duration time.Duration)
@@ -16,7 +23,37 @@ type IpcHandler interface {
 	// UnblockAddress allows a blocked IP address to access te server
 	UnblockAddress(addr net.IP)
 
-	handleCustomPacket(b []byte, serverKey string)
+	// DispatchEvent is called with a function that calls an event handler for a lifecycle event of the conn or one
+	// of its sessions. It should not call f synchronously if the event handler may block, as it is called while
+	// reading packets.
+	DispatchEvent(f func(h EventHandler))
+
+	// Options returns the options used by conns of the handler. NewConn calls it once, and uses the defaults for
+	// the logger, metrics, tracer and protocol if they are not set.
+	Options() *IpcOptions
+}
 
+// IpcHandler is a ConnHandler that is also a gophertunnel network, opening sessions on the conns it holds.
+type IpcHandler interface {
+	minecraft.Network
+	ConnHandler
+
+	// GetConn returns a conn with the key, or false if not found
 	GetConn(key string) (*Conn, bool)
 }
+
+// ConnRole is the side of the unix socket a Conn is on.
+type ConnRole uint8
+
+const (
+	// RoleServer is the role of conns accepted from PM servers that connect to us, such as those of an IpcServer
+	RoleServer ConnRole = iota
+	// RoleClient is the role of conns dialed to a PM server listening on a unix socket, such as those of an
+	// IpcClient
+	RoleClient
+)
+
+// FrameObserver is called with every IPC frame that a Conn sends or receives, in the order they are sent or
+// received, along with the protocol layout of the conn that the frame is encoded with. A frame holds the packet ID
+// followed by the payload, without the length prefix. Frames must not be retained after the call returns.
+type FrameObserver func(serverKey string, p *ipcprotocol.Protocol, sent bool, frame []byte)
@@ -1,16 +1,23 @@
 package gtipc
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"errors"
-	"log/slog"
+	"fmt"
+	"io"
+	"math/rand"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/gameparrot/gtipc/ipcprotocol"
 	"github.com/gameparrot/gtipc/ipcprotocol/rak2user"
+	"github.com/gameparrot/gtipc/ipcprotocol/versions"
 	"github.com/sandertv/gophertunnel/minecraft"
 	"github.com/sandertv/gophertunnel/minecraft/protocol/packet"
 )
@@ -26,18 +33,34 @@ type IpcServer struct {
 	connsMu        sync.RWMutex
 	socketPath     string
 
-	close chan bool
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	closeOnce sync.Once
+	closeErr  error
+
+	events *eventDispatcher
+
+	networkOnce sync.Once
+	network     string
+
+	switchersMu sync.Mutex
+	switchers   map[sessionRef]switcher
+
+	// drainWg tracks the goroutines that drain completion callbacks are called on, until drainWaited is set.
+	drainMu     sync.Mutex
+	drainWg     sync.WaitGroup
+	drainWaited bool
 
 	opts *IpcOptions
 }
 
 // NewIpcServer returns a new IPC server
 func NewIPCServer(socketPath string, opts *IpcOptions) (*IpcServer, error) {
-	if opts == nil {
-		opts = &IpcOptions{}
-	}
-	if opts.Log == nil {
-		opts.Log = slog.Default()
+	opts = opts.withDefaults()
+	if opts.Upstream != nil {
+		opts.Upstream.SetMetrics(opts.Metrics)
 	}
 
 	if _, err := os.Stat(socketPath); err == nil {
@@ -47,59 +70,118 @@ func NewIPCServer(socketPath string, opts *IpcOptions) (*IpcServer, error) {
 	if err != nil {
 		return nil, err
 	}
-	c := &IpcServer{listener: listener, ipcRaknetConns: make(map[string]*Conn), socketPath: socketPath, opts: opts, close: make(chan bool)}
-	go func() {
-		for {
-			conn, err := listener.Accept()
-			if err != nil {
-				continue
+	c := &IpcServer{listener: listener, ipcRaknetConns: make(map[string]*Conn), switchers: make(map[sessionRef]switcher), socketPath: socketPath, opts: opts, events: newEventDispatcher(opts.EventHandler, opts.Metrics)}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	c.wg.Add(1)
+	go c.acceptLoop()
+
+	if opts.Upstream != nil {
+		c.wg.Add(1)
+		go c.reportBandwidth()
+	}
+
+	return c, nil
+}
+
+// acceptLoop accepts PM servers until the listener is closed.
+func (l *IpcServer) acceptLoop() {
+	defer l.wg.Done()
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || l.ctx.Err() != nil {
+				return
 			}
-			go func() {
-				conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-				var nameLen = make([]byte, 1)
-				if _, err := conn.Read(nameLen); err != nil {
-					conn.Close()
-					return
-				}
-				var name = make([]byte, nameLen[0])
-				if _, err := conn.Read(name); err != nil {
-					conn.Close()
-					return
-				}
-				conn.SetReadDeadline(time.Time{})
-				ipcConn := NewConn(c.opts.Log, conn, string(name), c)
-				c.connsMu.Lock()
-				c.ipcRaknetConns[string(name)] = ipcConn
-				c.connsMu.Unlock()
-				ipcConn.ReadLoop()
-				c.connsMu.Lock()
-				delete(c.ipcRaknetConns, string(name))
-				c.connsMu.Unlock()
-			}()
+			l.opts.Log.Error("Failed to accept server", "err", err.Error())
+			time.Sleep(10 * time.Millisecond)
+			continue
 		}
-	}()
+		l.wg.Add(1)
+		go l.serveConn(conn)
+	}
+}
 
-	if opts.Upstream != nil {
-		ticker := time.NewTicker(1 * time.Second)
-		go func() {
-			for {
-				select {
-				case <-c.close:
-					return
-				case <-ticker.C:
-					c.connsMu.RLock()
-					for _, i := range c.ipcRaknetConns {
-						i.WritePacket(&rak2user.ReportBandwidthStats{SentBytesDiff: c.opts.Upstream.sentBytes, ReceivedBytesDiff: c.opts.Upstream.receivedBytes})
-					}
-					c.opts.Upstream.sentBytes = 0
-					c.opts.Upstream.receivedBytes = 0
-					c.connsMu.RUnlock()
-				}
+// serveConn performs the handshake with a PM server and reads its packets until it disconnects.
+func (l *IpcServer) serveConn(conn net.Conn) {
+	defer l.wg.Done()
+	name, protocol, err := l.handshake(conn)
+	if err != nil {
+		l.opts.Log.Error("Server handshake failed", "err", err.Error())
+		conn.Close()
+		return
+	}
+	ipcConn := NewConn(l.opts.Log, conn, name, l, RoleServer)
+	ipcConn.setProtocol(protocol)
+	l.connsMu.Lock()
+	if l.ctx.Err() != nil {
+		l.connsMu.Unlock()
+		conn.Close()
+		return
+	}
+	l.ipcRaknetConns[name] = ipcConn
+	l.connsMu.Unlock()
+	l.DispatchEvent(func(h EventHandler) { h.OnServerConnect(name) })
+	ipcConn.ReadLoop()
+	l.connsMu.Lock()
+	if l.ipcRaknetConns[name] == ipcConn {
+		delete(l.ipcRaknetConns, name)
+	}
+	l.connsMu.Unlock()
+	l.DispatchEvent(func(h EventHandler) { h.OnServerDisconnect(name) })
+}
+
+// reportBandwidth reports the bytes sent and received through the upstream handler to all servers every second.
+func (l *IpcServer) reportBandwidth() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.ctx.Done():
+			return
+		case <-ticker.C:
+			sent, received := l.opts.Upstream.sentBytes.Swap(0), l.opts.Upstream.receivedBytes.Swap(0)
+			for _, conn := range l.conns() {
+				conn.ReportBandwidth(sent, received)
 			}
-		}()
+		}
 	}
+}
 
-	return c, nil
+// conns returns the conns of all connected servers.
+func (l *IpcServer) conns() []*Conn {
+	l.connsMu.RLock()
+	defer l.connsMu.RUnlock()
+	conns := make([]*Conn, 0, len(l.ipcRaknetConns))
+	for _, conn := range l.ipcRaknetConns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// handshake reads the name a PM server sends after connecting, which is prefixed with its length. Selecting a layout
+// is an opt-in extension of gtipc that stock PM servers do not use: a server may append a zero byte and the version
+// of a registered layout to its name. Servers that send a plain name use the protocol set in the options.
+func (l *IpcServer) handshake(conn net.Conn) (string, *ipcprotocol.Protocol, error) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var nameLen = make([]byte, 1)
+	if _, err := io.ReadFull(conn, nameLen); err != nil {
+		return "", nil, err
+	}
+	var name = make([]byte, nameLen[0])
+	if _, err := io.ReadFull(conn, name); err != nil {
+		return "", nil, err
+	}
+	if i := bytes.IndexByte(name, 0); i != -1 && i == len(name)-2 {
+		protocol, ok := versions.Lookup(name[i+1])
+		if !ok {
+			return "", nil, fmt.Errorf("server %s requested unsupported protocol version %d", name[:i], name[i+1])
+		}
+		return string(name[:i]), protocol, nil
+	}
+	return string(name), l.opts.Protocol, nil
 }
 
 // BlockAddress blocks an IP address from accessing the server
@@ -130,33 +212,143 @@ func (l *IpcServer) GetConn(key string) (*Conn, bool) {
 	return conn, ok
 }
 
-// Close closes all conns and the unix socket
-func (l *IpcServer) Close() {
-	l.close <- true
-	l.connsMu.RLock()
-	defer l.connsMu.RUnlock()
-	for _, i := range l.ipcRaknetConns {
-		i.Close()
+// Close closes all sessions with DisconnectReasonServerShutdown, then the conns and the unix socket, and waits for
+// all goroutines of the server to return and the queued events to be handled. Later calls, and calls after Shutdown,
+// return the same error. Close must not be called from an event handler.
+func (l *IpcServer) Close() error {
+	l.closeOnce.Do(func() {
+		l.closeErr = l.shutdown(context.Background(), true)
+	})
+	return l.closeErr
+}
+
+// Shutdown stops accepting PM servers, sends every server a custom packet encoded with EncodeDrainNotice and waits
+// for all sessions to be closed. When ctx is done, remaining sessions are closed with
+// DisconnectReasonServerShutdown. The conns and the unix socket are then closed, and Shutdown waits for all
+// goroutines of the server to return and the queued events to be handled, until ctx is done. Shutdown returns the
+// errors encountered, and ctx.Err() if sessions had to be closed or goroutines were still running. Later calls wait
+// for the first to finish and return the same error. Shutdown must not be called from an event handler.
+func (l *IpcServer) Shutdown(ctx context.Context) error {
+	l.closeOnce.Do(func() {
+		l.closeErr = l.shutdown(ctx, false)
+	})
+	return l.closeErr
+}
+
+// shutdown shuts the server down. If immediate is set, sessions are closed without draining them first.
+func (l *IpcServer) shutdown(ctx context.Context, immediate bool) error {
+	var (
+		errs []error
+		// timedOut is set if sessions had to be closed or goroutines were left running when ctx was done.
+		timedOut bool
+	)
+	l.connsMu.Lock()
+	l.cancel()
+	l.connsMu.Unlock()
+	if err := l.listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		errs = append(errs, err)
 	}
-	l.listener.Close()
-	os.Remove(l.socketPath)
+
+	conns := l.conns()
+	if !immediate {
+		deadline, _ := ctx.Deadline()
+		drains := make([]*drainState, 0, len(conns))
+		for _, conn := range conns {
+			drains = append(drains, l.drain(conn, deadline, true))
+		}
+		for _, d := range drains {
+			select {
+			case <-d.done:
+				timedOut = timedOut || d.forced.Load() > 0
+			case <-ctx.Done():
+				timedOut = true
+			}
+		}
+	}
+	for _, conn := range conns {
+		conn.closeSessions(rak2user.DisconnectReasonServerShutdown)
+	}
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+	if err := os.Remove(l.socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs = append(errs, err)
+	}
+
+	// The goroutines and queued events are waited for until ctx is done, after which they are left to finish on
+	// their own.
+	waited := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		l.waitDrainComplete()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		timedOut = l.events.close(ctx) != nil || timedOut
+	case <-ctx.Done():
+		timedOut = true
+		_ = l.events.close(ctx)
+	}
+	if timedOut {
+		// Sessions may have been closed by the drain deadline just before ctx reports being done.
+		errs = append(errs, cmp.Or(ctx.Err(), context.DeadlineExceeded))
+	}
+	return errors.Join(errs...)
 }
 
+// DialContext opens a session on the server with the key in the address. The key may be followed by the address of
+// the client and then its client ID, separated by semicolons, like "key;203.0.113.7:19132;1234". A random client
+// ID is used if none is given. Use DialSession to forward metadata along with the session.
 func (l *IpcServer) DialContext(ctx context.Context, address string) (net.Conn, error) {
-	l.connsMu.RLock()
-	defer l.connsMu.RUnlock()
-
 	split := strings.Split(address, ";")
 	key := split[0]
-	conn, ok := l.ipcRaknetConns[key]
-	if !ok {
-		return nil, errNotFound
-	}
 	clientIp := ""
 	if len(split) > 1 {
 		clientIp = split[1]
 	}
-	return conn.OpenSession(clientIp)
+	clientID := rand.Int63()
+	if len(split) > 2 {
+		id, err := strconv.ParseInt(split[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client ID %q: %w", split[2], err)
+		}
+		clientID = id
+	}
+
+	conn, err := l.dialTarget(key)
+	if err != nil {
+		return nil, err
+	}
+	return conn.OpenSessionWithClientID(ctx, clientIp, clientID)
+}
+
+// dialTarget returns the conn of the server with the key to open a session on. If the server is draining, the conn
+// of the server returned by IpcOptions.DrainRedirect is returned instead. DrainRedirect is called without holding
+// connsMu, so that it may call methods of the IpcServer.
+func (l *IpcServer) dialTarget(key string) (*Conn, error) {
+	conn, ok := l.GetConn(key)
+	if !ok {
+		return nil, errNotFound
+	}
+	if !conn.draining() {
+		return conn, nil
+	}
+	if l.opts.DrainRedirect == nil {
+		return nil, ErrDraining
+	}
+	redirect, ok := l.opts.DrainRedirect(key)
+	if !ok {
+		return nil, ErrDraining
+	}
+	if conn, ok = l.GetConn(redirect); !ok {
+		return nil, errNotFound
+	}
+	if conn.draining() {
+		return nil, ErrDraining
+	}
+	return conn, nil
 }
 
 func (l *IpcServer) PingContext(ctx context.Context, address string) (response []byte, err error) {
@@ -167,17 +359,35 @@ func (l *IpcServer) PingContext(ctx context.Context, address string) (response [
 	if !ok {
 		return nil, errNotFound
 	}
-	return conn.pongData, nil
+	return conn.pingResponse(), nil
 }
 
 func (l *IpcServer) Listen(address string) (minecraft.NetworkListener, error) {
 	return nil, errors.New("not supported")
 }
 
-func (l *IpcServer) handleCustomPacket(b []byte, serverKey string) {
+// HandleCustomPacket performs the transfer if the packet is a transfer command, and passes it to the custom packet
+// handler in the options otherwise.
+func (l *IpcServer) HandleCustomPacket(b []byte, serverKey string) {
+	if sessionID, target, ok := DecodeTransferCommand(b); ok {
+		if err := l.transfer(serverKey, sessionID, target); err != nil {
+			l.opts.Log.Error("Failed to transfer session", "from", serverKey, "session", sessionID, "to", target, "err", err.Error())
+		}
+		return
+	}
 	if l.opts.CustomPacketHandler != nil {
 		l.opts.CustomPacketHandler(b, serverKey)
 	}
 }
 
+// DispatchEvent queues f to be called with the event handler in the options.
+func (l *IpcServer) DispatchEvent(f func(h EventHandler)) {
+	l.events.dispatch(f)
+}
+
+// Options returns the options of the server.
+func (l *IpcServer) Options() *IpcOptions {
+	return l.opts
+}
+
 func (*IpcServer) Compression(net.Conn) packet.Compression { return packet.FlateCompression }
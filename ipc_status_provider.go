@@ -1,27 +1,63 @@
 package gtipc
 
 import (
-	_ "unsafe"
+	"slices"
 
 	"github.com/sandertv/gophertunnel/minecraft"
 )
 
+// IpcStatusProvider is a status provider that reports the status of one or more PM servers. When several servers
+// are used, player counts and max players are summed and the MOTD is taken from the primary server.
 type IpcStatusProvider struct {
-	key     string
-	handler IpcHandler
+	primary  string
+	keys     []string
+	fallback minecraft.ServerStatus
+	handler  IpcHandler
 }
 
+// NewIpcStatusProvider returns a status provider that reports the status of the server with the key
 func NewIpcStatusProvider(key string, handler IpcHandler) *IpcStatusProvider {
-	return &IpcStatusProvider{key: key, handler: handler}
+	return NewAggregateStatusProvider(handler, key, nil, minecraft.ServerStatus{})
 }
 
-func (i *IpcStatusProvider) ServerStatus(int, int) minecraft.ServerStatus {
-	conn, ok := i.handler.GetConn(i.key)
-	if !ok {
-		return minecraft.ServerStatus{}
+// NewAggregateStatusProvider returns a status provider that merges the status of the primary server and the
+// other servers passed. If the primary server is not connected, the MOTD of the first connected server is used.
+// The fallback status is returned if none of the servers are connected.
+func NewAggregateStatusProvider(handler IpcHandler, primary string, others []string, fallback minecraft.ServerStatus) *IpcStatusProvider {
+	keys := []string{primary}
+	for _, key := range others {
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
 	}
-	return parsePongData(conn.pongData)
+	return &IpcStatusProvider{primary: primary, keys: keys, fallback: fallback, handler: handler}
 }
 
-//go:linkname parsePongData github.com/sandertv/gophertunnel/minecraft.parsePongData
-func parsePongData(pong []byte) minecraft.ServerStatus
+func (i *IpcStatusProvider) ServerStatus(int, int) minecraft.ServerStatus {
+	var (
+		status    minecraft.ServerStatus
+		connected bool
+	)
+	for _, key := range i.keys {
+		conn, ok := i.handler.GetConn(key)
+		if !ok {
+			continue
+		}
+		pong, err := conn.pong()
+		if err != nil {
+			continue
+		}
+		s := pong.ServerStatus()
+		if !connected || key == i.primary {
+			status.ServerName = s.ServerName
+			status.ServerSubName = s.ServerSubName
+		}
+		status.PlayerCount += s.PlayerCount
+		status.MaxPlayers += s.MaxPlayers
+		connected = true
+	}
+	if !connected {
+		return i.fallback
+	}
+	return status
+}
@@ -0,0 +1,33 @@
+package ipcprotocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/gameparrot/gtipc/ipcprotocol/io"
+)
+
+// Decode decodes a frame without its length prefix into a packet from the pool passed.
+func Decode(pool map[uint8]func() Packet, frame []byte) (pk Packet, err error) {
+	if len(frame) == 0 {
+		return nil, errors.New("empty frame")
+	}
+	packetFunc, ok := pool[frame[0]]
+	if !ok {
+		return nil, fmt.Errorf("invalid packet id %d", frame[0])
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			pk = nil
+			if rErr, ok := r.(error); ok {
+				err = fmt.Errorf("decode packet %d: %w", frame[0], rErr)
+			} else {
+				err = fmt.Errorf("decode packet %d: %v", frame[0], r)
+			}
+		}
+	}()
+	pk = packetFunc()
+	pk.Marshal(io.NewReader(bytes.NewReader(frame[1:])), len(frame)-1)
+	return pk, nil
+}
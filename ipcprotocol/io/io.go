@@ -1,6 +1,7 @@
 package io
 
 import (
+	"io"
 	"unsafe"
 )
 
@@ -44,6 +45,19 @@ func FuncSliceUint8Length[T any, S ~*[]T](r IO, x S, f func(*T)) {
 	FuncSliceOfLen(r, uint32(count), x, f)
 }
 
+// PayloadLen returns the length of a payload that takes up the rest of a packet. When writing, this is payloadLen,
+// the length of the payload. When reading, it is the number of bytes left in the packet of the length passed.
+func PayloadLen(r IO, length int, payloadLen int) uint32 {
+	if _, reader := r.(*Reader); !reader {
+		return uint32(payloadLen)
+	}
+	remaining := length - int(r.Offset())
+	if remaining < 0 {
+		panic(io.ErrUnexpectedEOF)
+	}
+	return uint32(remaining)
+}
+
 // String reads a string from the underlying buffer.
 func StringUint8Length(x *string, r IO) {
 	var length uint8 = uint8(len(*x))
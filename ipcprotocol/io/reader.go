@@ -51,7 +51,7 @@ func (r *Reader) String(x *string) {
 	r.Uint32(&length)
 	l := int(length)
 	data := make([]byte, l)
-	if _, err := r.r.Read(data); err != nil {
+	if _, err := io.ReadFull(&r.r, data); err != nil {
 		r.panic(err)
 	}
 	*x = *(*string)(unsafe.Pointer(&data))
@@ -84,7 +84,7 @@ func (r *Reader) StringVaruint32(x *string) {
 	}
 	l := int(length)
 	data := make([]byte, l)
-	if _, err := r.r.Read(data); err != nil {
+	if _, err := io.ReadFull(&r.r, data); err != nil {
 		r.panic(err)
 	}
 	*x = *(*string)(unsafe.Pointer(&data))
@@ -98,7 +98,7 @@ func (r *Reader) panic(err error) {
 // Uint16 reads a little endian uint16 from the underlying buffer.
 func (r *Reader) Uint16(x *uint16) {
 	b := make([]byte, 2)
-	if _, err := r.r.Read(b); err != nil {
+	if _, err := io.ReadFull(&r.r, b); err != nil {
 		r.panic(err)
 	}
 	*x = binary.LittleEndian.Uint16(b)
@@ -107,7 +107,7 @@ func (r *Reader) Uint16(x *uint16) {
 // BEUint16 reads a big endian uint16 from the underlying buffer.
 func (r *Reader) BEUint16(x *uint16) {
 	b := make([]byte, 2)
-	if _, err := r.r.Read(b); err != nil {
+	if _, err := io.ReadFull(&r.r, b); err != nil {
 		r.panic(err)
 	}
 	*x = binary.BigEndian.Uint16(b)
@@ -116,7 +116,7 @@ func (r *Reader) BEUint16(x *uint16) {
 // Int16 reads a little endian int16 from the underlying buffer.
 func (r *Reader) Int16(x *int16) {
 	b := make([]byte, 2)
-	if _, err := r.r.Read(b); err != nil {
+	if _, err := io.ReadFull(&r.r, b); err != nil {
 		r.panic(err)
 	}
 	*x = int16(binary.LittleEndian.Uint16(b))
@@ -125,7 +125,7 @@ func (r *Reader) Int16(x *int16) {
 // Uint32 reads a little endian uint32 from the underlying buffer.
 func (r *Reader) Uint32(x *uint32) {
 	b := make([]byte, 4)
-	if _, err := r.r.Read(b); err != nil {
+	if _, err := io.ReadFull(&r.r, b); err != nil {
 		r.panic(err)
 	}
 	*x = binary.LittleEndian.Uint32(b)
@@ -134,7 +134,7 @@ func (r *Reader) Uint32(x *uint32) {
 // BEUint32 reads a big endian uint32 from the underlying buffer.
 func (r *Reader) BEUint32(x *uint32) {
 	b := make([]byte, 4)
-	if _, err := r.r.Read(b); err != nil {
+	if _, err := io.ReadFull(&r.r, b); err != nil {
 		r.panic(err)
 	}
 	*x = binary.BigEndian.Uint32(b)
@@ -143,7 +143,7 @@ func (r *Reader) BEUint32(x *uint32) {
 // Int32 reads a little endian int32 from the underlying buffer.
 func (r *Reader) Int32(x *int32) {
 	b := make([]byte, 4)
-	if _, err := r.r.Read(b); err != nil {
+	if _, err := io.ReadFull(&r.r, b); err != nil {
 		r.panic(err)
 	}
 	*x = int32(binary.LittleEndian.Uint32(b))
@@ -152,7 +152,7 @@ func (r *Reader) Int32(x *int32) {
 // BEInt32 reads a big endian int32 from the underlying buffer.
 func (r *Reader) BEInt32(x *int32) {
 	b := make([]byte, 4)
-	if _, err := r.r.Read(b); err != nil {
+	if _, err := io.ReadFull(&r.r, b); err != nil {
 		r.panic(err)
 	}
 	*x = int32(binary.BigEndian.Uint32(b))
@@ -161,7 +161,7 @@ func (r *Reader) BEInt32(x *int32) {
 // Uint64 reads a little endian uint64 from the underlying buffer.
 func (r *Reader) Uint64(x *uint64) {
 	b := make([]byte, 8)
-	if _, err := r.r.Read(b); err != nil {
+	if _, err := io.ReadFull(&r.r, b); err != nil {
 		r.panic(err)
 	}
 	*x = binary.LittleEndian.Uint64(b)
@@ -170,7 +170,7 @@ func (r *Reader) Uint64(x *uint64) {
 // Int64 reads a little endian int64 from the underlying buffer.
 func (r *Reader) Int64(x *int64) {
 	b := make([]byte, 8)
-	if _, err := r.r.Read(b); err != nil {
+	if _, err := io.ReadFull(&r.r, b); err != nil {
 		r.panic(err)
 	}
 	*x = int64(binary.LittleEndian.Uint64(b))
@@ -179,7 +179,7 @@ func (r *Reader) Int64(x *int64) {
 // BEInt64 reads a big endian int64 from the underlying buffer.
 func (r *Reader) BEInt64(x *int64) {
 	b := make([]byte, 8)
-	if _, err := r.r.Read(b); err != nil {
+	if _, err := io.ReadFull(&r.r, b); err != nil {
 		r.panic(err)
 	}
 	*x = int64(binary.BigEndian.Uint64(b))
@@ -188,7 +188,7 @@ func (r *Reader) BEInt64(x *int64) {
 // Float32 reads a little endian float32 from the underlying buffer.
 func (r *Reader) Float32(x *float32) {
 	b := make([]byte, 4)
-	if _, err := r.r.Read(b); err != nil {
+	if _, err := io.ReadFull(&r.r, b); err != nil {
 		r.panic(err)
 	}
 	*x = math.Float32frombits(binary.LittleEndian.Uint32(b))
@@ -0,0 +1,63 @@
+package ipcprotocol
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/gameparrot/gtipc/ipcprotocol/io"
+)
+
+// Protocol is a layout of the RakLib IPC protocol. Layouts used by different PM versions may assign different IDs
+// to the same packets, and may not support every packet.
+type Protocol struct {
+	version  uint8
+	user2Rak map[uint8]func() Packet
+	rak2User map[uint8]func() Packet
+	ids      map[reflect.Type]uint8
+}
+
+// NewProtocol returns a protocol layout with the version and packet pools passed. The pools map the IDs of packets
+// in the layout to functions returning a new packet.
+func NewProtocol(version uint8, user2Rak, rak2User map[uint8]func() Packet) *Protocol {
+	p := &Protocol{version: version, user2Rak: user2Rak, rak2User: rak2User, ids: make(map[reflect.Type]uint8)}
+	for _, pool := range []map[uint8]func() Packet{user2Rak, rak2User} {
+		for id, f := range pool {
+			p.ids[reflect.TypeOf(f())] = id
+		}
+	}
+	return p
+}
+
+// Version returns the version of the layout.
+func (p *Protocol) Version() uint8 {
+	return p.version
+}
+
+// User2RakPool returns the pool of packets sent by PM servers. The pool must not be modified.
+func (p *Protocol) User2RakPool() map[uint8]func() Packet {
+	return p.user2Rak
+}
+
+// Rak2UserPool returns the pool of packets sent to PM servers. The pool must not be modified.
+func (p *Protocol) Rak2UserPool() map[uint8]func() Packet {
+	return p.rak2User
+}
+
+// ID returns the ID of a packet in the layout, or false if the layout does not support the packet.
+func (p *Protocol) ID(pk Packet) (uint8, bool) {
+	id, ok := p.ids[reflect.TypeOf(pk)]
+	return id, ok
+}
+
+// Encode encodes a packet into a frame using the ID the packet has in the layout.
+func (p *Protocol) Encode(pk Packet) ([]byte, error) {
+	id, ok := p.ID(pk)
+	if !ok {
+		return nil, fmt.Errorf("packet %T is not supported by protocol version %d", pk, p.version)
+	}
+	buf := bytes.NewBuffer(nil)
+	buf.WriteByte(id)
+	pk.Marshal(io.NewWriter(buf), 0)
+	return buf.Bytes(), nil
+}
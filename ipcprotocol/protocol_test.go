@@ -0,0 +1,209 @@
+package ipcprotocol_test
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/gameparrot/gtipc/ipcprotocol"
+	"github.com/gameparrot/gtipc/ipcprotocol/rak2user"
+	"github.com/gameparrot/gtipc/ipcprotocol/user2rak"
+	"github.com/gameparrot/gtipc/ipcprotocol/versions"
+)
+
+// compact is a layout without SetName and ReportBandwidthStats whose packet IDs have no gaps, so that the tables are
+// also run against a layout other than V1.
+var compact = ipcprotocol.NewProtocol(200, map[uint8]func() ipcprotocol.Packet{
+	1: func() ipcprotocol.Packet { return &user2rak.Encapsulated{} },
+	2: func() ipcprotocol.Packet { return &user2rak.CloseSession{} },
+	3: func() ipcprotocol.Packet { return &user2rak.Raw{} },
+	4: func() ipcprotocol.Packet { return &user2rak.BlockAddress{} },
+	5: func() ipcprotocol.Packet { return &user2rak.UnblockAddress{} },
+	6: func() ipcprotocol.Packet { return &user2rak.RawFilter{} },
+}, map[uint8]func() ipcprotocol.Packet{
+	1: func() ipcprotocol.Packet { return &rak2user.Encapsulated{} },
+	2: func() ipcprotocol.Packet { return &rak2user.OpenSession{} },
+	3: func() ipcprotocol.Packet { return &rak2user.CloseSession{} },
+	4: func() ipcprotocol.Packet { return &rak2user.AckNotification{} },
+	5: func() ipcprotocol.Packet { return &rak2user.Raw{} },
+	6: func() ipcprotocol.Packet { return &rak2user.ReportPing{} },
+})
+
+// layouts are the protocol layouts that are tested.
+var layouts = []*ipcprotocol.Protocol{versions.V1, compact}
+
+// rak2UserPackets holds at least one packet of every rak2user type, including edge cases of payloads whose length is
+// derived from the frame length. Packets that a layout does not support are skipped for it.
+var rak2UserPackets = []ipcprotocol.Packet{
+	&rak2user.Encapsulated{SessionID: 1, UserPayload: []byte{0xfe, 0x01, 0x02}},
+	&rak2user.Encapsulated{SessionID: -1, UserPayload: []byte("custom")},
+	&rak2user.Encapsulated{SessionID: 7, UserPayload: []byte{}},
+	&rak2user.OpenSession{SessionID: 1, Addr: net.IP{203, 0, 113, 7}, Port: 19132, ClientID: 1234567890123},
+	&rak2user.OpenSession{SessionID: 2, Addr: net.ParseIP("2001:db8::1"), Port: 1, ClientID: -1},
+	&rak2user.CloseSession{SessionID: 3, Reason: rak2user.DisconnectReasonServerShutdown},
+	&rak2user.AckNotification{SessionID: 4, ACK: 99},
+	&rak2user.ReportBandwidthStats{SentBytesDiff: 1 << 40, ReceivedBytesDiff: -5},
+	&rak2user.Raw{SessionID: 5, Addr: "203.0.113.7", Port: 19132, Payload: []byte{0x01, 0x00}},
+	&rak2user.Raw{SessionID: 6, Addr: "", Port: 0, Payload: []byte{}},
+	&rak2user.ReportPing{SessionID: 8, Ping: 42},
+}
+
+// user2RakPackets holds at least one packet of every user2rak type, including edge cases of payloads whose length is
+// derived from the frame length. Packets that a layout does not support are skipped for it.
+var user2RakPackets = []ipcprotocol.Packet{
+	&user2rak.Encapsulated{SessionID: 1, Reliability: 3, OrderChannel: 2, UserPayload: []byte{0xfe, 0x01}},
+	&user2rak.Encapsulated{SessionID: 1, Flags: 1, Reliability: 3, Ack: 17, UserPayload: []byte{0x02}},
+	&user2rak.Encapsulated{SessionID: 2, Reliability: 0, UserPayload: []byte{0x03}},
+	&user2rak.Encapsulated{SessionID: 2, Flags: 1, Reliability: 5, Ack: -3, UserPayload: []byte{}},
+	&user2rak.Encapsulated{SessionID: -1, UserPayload: []byte("custom")},
+	&user2rak.CloseSession{SessionID: 3},
+	&user2rak.Raw{Addr: "203.0.113.7", Port: 19132, Payload: []byte{0x01, 0x02}},
+	&user2rak.Raw{Addr: "", Payload: []byte{}},
+	&user2rak.BlockAddress{Addr: "203.0.113.7", Timeout: 300},
+	&user2rak.BlockAddress{Addr: "2001:db8::1", Timeout: 0},
+	&user2rak.UnblockAddress{Addr: "203.0.113.7"},
+	&user2rak.RawFilter{Filter: "\xfe\xff"},
+	&user2rak.RawFilter{Filter: ""},
+	&user2rak.SetName{Name: []byte("MCPE;Server;712;1.21.20;0;20;1;Sub;Survival;1;19132;19133;")},
+	&user2rak.SetName{Name: []byte{}},
+}
+
+func TestLayouts(t *testing.T) {
+	pong := []byte("MCPE;")
+	for _, tc := range []struct {
+		layout *ipcprotocol.Protocol
+		pk     ipcprotocol.Packet
+		// frame is the expected frame, or nil if the layout does not support the packet.
+		frame []byte
+	}{
+		{versions.V1, &user2rak.SetName{Name: pong}, append([]byte{8}, pong...)},
+		{compact, &user2rak.SetName{Name: pong}, nil},
+		{versions.V1, &rak2user.ReportBandwidthStats{SentBytesDiff: 1, ReceivedBytesDiff: 2}, []byte{5, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 2}},
+		{compact, &rak2user.ReportBandwidthStats{SentBytesDiff: 1, ReceivedBytesDiff: 2}, nil},
+		{versions.V1, &user2rak.Raw{Addr: "a", Port: 1, Payload: []byte{9}}, []byte{4, 1, 'a', 0, 1, 9}},
+		{compact, &user2rak.Raw{Addr: "a", Port: 1, Payload: []byte{9}}, []byte{3, 1, 'a', 0, 1, 9}},
+		{versions.V1, &rak2user.Raw{SessionID: 1, Addr: "a", Port: 1, Payload: []byte{9}}, []byte{6, 0, 0, 0, 1, 1, 'a', 0, 1, 9}},
+		{compact, &rak2user.Raw{SessionID: 1, Addr: "a", Port: 1, Payload: []byte{9}}, []byte{5, 0, 0, 0, 1, 1, 'a', 0, 1, 9}},
+		{versions.V1, &rak2user.ReportPing{SessionID: 1, Ping: 2}, []byte{7, 0, 0, 0, 1, 0, 0, 0, 2}},
+		{compact, &rak2user.ReportPing{SessionID: 1, Ping: 2}, []byte{6, 0, 0, 0, 1, 0, 0, 0, 2}},
+	} {
+		frame, err := tc.layout.Encode(tc.pk)
+		if tc.frame == nil {
+			if err == nil {
+				t.Errorf("v%d: encode %T: expected an error as the layout does not support it", tc.layout.Version(), tc.pk)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("v%d: encode %T: %v", tc.layout.Version(), tc.pk, err)
+			continue
+		}
+		if !bytes.Equal(frame, tc.frame) {
+			t.Errorf("v%d: %T encodes to %x, want %x", tc.layout.Version(), tc.pk, frame, tc.frame)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, p := range layouts {
+		for _, tc := range []struct {
+			name    string
+			pool    map[uint8]func() ipcprotocol.Packet
+			packets []ipcprotocol.Packet
+		}{
+			{"rak2user", p.Rak2UserPool(), rak2UserPackets},
+			{"user2rak", p.User2RakPool(), user2RakPackets},
+		} {
+			t.Run(fmt.Sprintf("v%d/%s", p.Version(), tc.name), func(t *testing.T) {
+				covered := make(map[uint8]bool)
+				for _, pk := range tc.packets {
+					id, ok := p.ID(pk)
+					if !ok {
+						continue
+					}
+					covered[id] = true
+					frame, err := p.Encode(pk)
+					if err != nil {
+						t.Fatalf("encode %T: %v", pk, err)
+					}
+					if frame[0] != id {
+						t.Fatalf("encode %T: frame has ID %d, want %d", pk, frame[0], id)
+					}
+					decoded, err := ipcprotocol.Decode(tc.pool, frame)
+					if err != nil {
+						t.Fatalf("decode %T: %v", pk, err)
+					}
+					if !reflect.DeepEqual(decoded, pk) {
+						t.Fatalf("round trip of %T:\ngot  %#v\nwant %#v", pk, decoded, pk)
+					}
+				}
+				for id, f := range tc.pool {
+					if !covered[id] {
+						t.Errorf("no packets of type %T with ID %d are tested", f(), id)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	for _, p := range layouts {
+		for _, pk := range append(append([]ipcprotocol.Packet{}, rak2UserPackets...), user2RakPackets...) {
+			frame, err := p.Encode(pk)
+			if err != nil {
+				continue
+			}
+			pool := p.User2RakPool()
+			if _, ok := pool[frame[0]]; !ok || reflect.TypeOf(pool[frame[0]]()) != reflect.TypeOf(pk) {
+				pool = p.Rak2UserPool()
+			}
+			// Every prefix of a frame must either decode or return an error, but never panic.
+			for n := range len(frame) {
+				_, _ = ipcprotocol.Decode(pool, frame[:n])
+			}
+		}
+	}
+}
+
+// FuzzDecode decodes frames with the pools of every layout. Frames that decode must encode to a frame that decodes
+// to the same packet.
+func FuzzDecode(f *testing.F) {
+	for _, p := range layouts {
+		for _, pk := range rak2UserPackets {
+			if frame, err := p.Encode(pk); err == nil {
+				f.Add(false, frame)
+			}
+		}
+		for _, pk := range user2RakPackets {
+			if frame, err := p.Encode(pk); err == nil {
+				f.Add(true, frame)
+			}
+		}
+	}
+	f.Fuzz(func(t *testing.T, fromServer bool, frame []byte) {
+		for _, p := range layouts {
+			pool := p.Rak2UserPool()
+			if fromServer {
+				pool = p.User2RakPool()
+			}
+			pk, err := ipcprotocol.Decode(pool, frame)
+			if err != nil {
+				continue
+			}
+			encoded, err := p.Encode(pk)
+			if err != nil {
+				t.Fatalf("v%d: encode decoded %T: %v", p.Version(), pk, err)
+			}
+			again, err := ipcprotocol.Decode(pool, encoded)
+			if err != nil {
+				t.Fatalf("v%d: decode re-encoded %T: %v", p.Version(), pk, err)
+			}
+			if !reflect.DeepEqual(again, pk) {
+				t.Fatalf("v%d: re-encoded %T decodes differently:\ngot  %#v\nwant %#v", p.Version(), pk, again, pk)
+			}
+		}
+	})
+}
@@ -14,9 +14,5 @@ func (e *Encapsulated) ID() uint8 {
 func (e *Encapsulated) Marshal(i io.IO, length int) {
 	i.BEInt32(&e.SessionID)
 
-	pkLen := len(e.UserPayload)
-	if i.Offset() != 0 {
-		pkLen = length - int(i.Offset())
-	}
-	io.FuncSliceOfLen(i, uint32(pkLen), &e.UserPayload, i.Uint8)
+	io.FuncSliceOfLen(i, io.PayloadLen(i, length, len(e.UserPayload)), &e.UserPayload, i.Uint8)
 }
@@ -21,6 +21,7 @@ func (o *OpenSession) Marshal(i io.IO, len int) {
 	i.BEInt32(&o.SessionID)
 	addr := []byte(o.Addr)
 	io.FuncSliceUint8Length(i, &addr, i.Uint8)
+	o.Addr = addr
 	i.BEUint16(&o.Port)
 	i.BEInt64(&o.ClientID)
 }
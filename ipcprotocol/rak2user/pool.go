@@ -7,12 +7,18 @@ func NewRak2UserPool() map[uint8]func() ipcprotocol.Packet {
 		IdEncapsulated: func() ipcprotocol.Packet {
 			return &Encapsulated{}
 		},
+		IdOpenSession: func() ipcprotocol.Packet {
+			return &OpenSession{}
+		},
 		IdCloseSession: func() ipcprotocol.Packet {
 			return &CloseSession{}
 		},
 		IdAckNotification: func() ipcprotocol.Packet {
 			return &AckNotification{}
 		},
+		IdReportBandwidthStats: func() ipcprotocol.Packet {
+			return &ReportBandwidthStats{}
+		},
 		IdRaw: func() ipcprotocol.Packet {
 			return &Raw{}
 		},
@@ -29,9 +29,5 @@ func (e *Encapsulated) Marshal(i io.IO, length int) {
 		i.Uint8(&e.OrderChannel)
 	}
 
-	pkLen := len(e.UserPayload)
-	if i.Offset() != 0 {
-		pkLen = length - int(i.Offset())
-	}
-	io.FuncSliceOfLen(i, uint32(pkLen), &e.UserPayload, i.Uint8)
+	io.FuncSliceOfLen(i, io.PayloadLen(i, length, len(e.UserPayload)), &e.UserPayload, i.Uint8)
 }
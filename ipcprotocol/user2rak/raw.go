@@ -16,9 +16,5 @@ func (r *Raw) Marshal(i io.IO, length int) {
 	io.StringUint8Length(&r.Addr, i)
 	i.BEUint16(&r.Port)
 
-	pkLen := len(r.Payload)
-	if i.Offset() != 0 {
-		pkLen = length - int(i.Offset())
-	}
-	io.FuncSliceOfLen(i, uint32(pkLen), &r.Payload, i.Uint8)
+	io.FuncSliceOfLen(i, io.PayloadLen(i, length, len(r.Payload)), &r.Payload, i.Uint8)
 }
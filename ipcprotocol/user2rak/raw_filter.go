@@ -10,6 +10,6 @@ func (r *RawFilter) ID() uint8 {
 	return IdRawFilter
 }
 
-func (r *RawFilter) Marshal(i io.IO, len int) {
-	io.StringOfLen(&r.Filter, uint32(len), i)
+func (r *RawFilter) Marshal(i io.IO, length int) {
+	io.StringOfLen(&r.Filter, io.PayloadLen(i, length, len(r.Filter)), i)
 }
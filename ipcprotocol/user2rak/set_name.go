@@ -10,6 +10,6 @@ func (s *SetName) ID() uint8 {
 	return IdSetName
 }
 
-func (s *SetName) Marshal(i io.IO, len int) {
-	io.FuncSliceOfLen(i, uint32(len), &s.Name, i.Uint8)
+func (s *SetName) Marshal(i io.IO, length int) {
+	io.FuncSliceOfLen(i, io.PayloadLen(i, length, len(s.Name)), &s.Name, i.Uint8)
 }
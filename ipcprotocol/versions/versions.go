@@ -0,0 +1,38 @@
+// Package versions holds the layouts of the RakLib IPC protocol supported by gtipc.
+package versions
+
+import (
+	"sync"
+
+	"github.com/gameparrot/gtipc/ipcprotocol"
+	"github.com/gameparrot/gtipc/ipcprotocol/rak2user"
+	"github.com/gameparrot/gtipc/ipcprotocol/user2rak"
+)
+
+// V1 is the layout used by the PM RakLib IPC module that gtipc was written against. PM servers that do not send a
+// version in the handshake are assumed to use it.
+var V1 = ipcprotocol.NewProtocol(1, user2rak.NewUser2RakPool(), rak2user.NewRak2UserPool())
+
+// Latest is the latest layout supported.
+var Latest = V1
+
+var (
+	mu       sync.RWMutex
+	registry = map[uint8]*ipcprotocol.Protocol{V1.Version(): V1}
+)
+
+// Register registers a layout so that PM servers can select it by its version in the handshake. Registering a
+// layout with the version of an existing one replaces it.
+func Register(p *ipcprotocol.Protocol) {
+	mu.Lock()
+	registry[p.Version()] = p
+	mu.Unlock()
+}
+
+// Lookup returns the layout registered with the version passed.
+func Lookup(version uint8) (*ipcprotocol.Protocol, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	p, ok := registry[version]
+	return p, ok
+}
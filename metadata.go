@@ -0,0 +1,174 @@
+package gtipc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand"
+	"slices"
+)
+
+// sessionMetadataPrefix starts custom packets that forward metadata of a session to a PM server. It is followed by
+// the session ID as a big endian int32, the amount of entries as a big endian uint16 and the entries, sorted by key.
+// Keys and values are each prefixed with their length as a big endian uint16.
+var sessionMetadataPrefix = []byte("gtipc:session\x00")
+
+// Metadata keys set by DialSession.
+const (
+	// MetadataClientAddr is the key of the address of the client, as passed in SessionOptions.ClientAddr
+	MetadataClientAddr = "client_addr"
+	// MetadataXUID is the key of the XUID of the player, as passed in SessionOptions.XUID
+	MetadataXUID = "xuid"
+)
+
+// SessionOptions are the options a session is opened with by DialSession.
+type SessionOptions struct {
+	// ClientAddr is the address of the client, such as 203.0.113.7:19132 or [2001:db8::1]:19132, as parsed by
+	// ParseClientAddr. The server sees an address made up from the session ID if it is not set
+	ClientAddr string
+	// ClientID is the RakNet client ID sent to the server. A random ID is used if it is zero
+	ClientID int64
+	// XUID is the XUID of the player, as verified by the proxy
+	XUID string
+	// Metadata is forwarded to the server along with the XUID and client address, for information the IPC protocol
+	// can't carry, such as the region of the proxy
+	Metadata map[string]string
+}
+
+// metadata returns the entries forwarded to the server for the options, or nil if there is nothing to forward
+// besides the client address.
+func (o SessionOptions) metadata() map[string]string {
+	if o.XUID == "" && len(o.Metadata) == 0 {
+		return nil
+	}
+	m := maps.Clone(o.Metadata)
+	if m == nil {
+		m = make(map[string]string, 2)
+	}
+	if o.XUID != "" {
+		m[MetadataXUID] = o.XUID
+	}
+	if o.ClientAddr != "" {
+		m[MetadataClientAddr] = o.ClientAddr
+	}
+	return m
+}
+
+// EncodeSessionMetadata returns the payload of the custom packet that forwards metadata of a session to a PM
+// server. PM plugins decode it to read the real address or the XUID of a player. An error is returned if there are
+// more than 65535 entries, or if a key or value is longer than 65535 bytes.
+func EncodeSessionMetadata(sessionID int32, metadata map[string]string) ([]byte, error) {
+	if len(metadata) > math.MaxUint16 {
+		return nil, fmt.Errorf("session metadata has %d entries, at most %d are allowed", len(metadata), math.MaxUint16)
+	}
+	b := bytes.Clone(sessionMetadataPrefix)
+	b = binary.BigEndian.AppendUint32(b, uint32(sessionID))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(metadata)))
+	for _, k := range slices.Sorted(maps.Keys(metadata)) {
+		for _, s := range []string{k, metadata[k]} {
+			if len(s) > math.MaxUint16 {
+				return nil, fmt.Errorf("session metadata entry %q is longer than %d bytes", k, math.MaxUint16)
+			}
+			b = binary.BigEndian.AppendUint16(b, uint16(len(s)))
+			b = append(b, s...)
+		}
+	}
+	return b, nil
+}
+
+// DecodeSessionMetadata decodes the payload of a custom packet encoded with EncodeSessionMetadata. False is returned
+// if the payload is not session metadata.
+func DecodeSessionMetadata(b []byte) (sessionID int32, metadata map[string]string, ok bool) {
+	rest, ok := bytes.CutPrefix(b, sessionMetadataPrefix)
+	if !ok || len(rest) < 6 {
+		return 0, nil, false
+	}
+	sessionID = int32(binary.BigEndian.Uint32(rest))
+	n := int(binary.BigEndian.Uint16(rest[4:]))
+	rest = rest[6:]
+
+	readString := func() (string, bool) {
+		if len(rest) < 2 {
+			return "", false
+		}
+		l := int(binary.BigEndian.Uint16(rest))
+		if len(rest) < 2+l {
+			return "", false
+		}
+		s := string(rest[2 : 2+l])
+		rest = rest[2+l:]
+		return s, true
+	}
+	metadata = make(map[string]string, n)
+	for range n {
+		k, ok := readString()
+		if !ok {
+			return 0, nil, false
+		}
+		v, ok := readString()
+		if !ok {
+			return 0, nil, false
+		}
+		metadata[k] = v
+	}
+	if len(rest) != 0 {
+		return 0, nil, false
+	}
+	return sessionID, metadata, true
+}
+
+// OpenSessionWithOptions opens a new session on the PM server with the options passed. If there is metadata to
+// forward, it is sent in a custom packet encoded with EncodeSessionMetadata right after the session is opened, so
+// that the server receives it before any payload of the session. The session is closed if the metadata can't be
+// sent.
+func (c *Conn) OpenSessionWithOptions(ctx context.Context, opts SessionOptions) (Session, error) {
+	metadata := opts.metadata()
+	var payload []byte
+	if metadata != nil {
+		// The session ID is filled in once the session is opened.
+		b, err := EncodeSessionMetadata(0, metadata)
+		if err != nil {
+			return nil, err
+		}
+		payload = b
+	}
+	clientID := opts.ClientID
+	if clientID == 0 {
+		clientID = rand.Int63()
+	}
+	session, err := c.openSession(ctx, opts.ClientAddr, clientID, metadata)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		binary.BigEndian.PutUint32(payload[len(sessionMetadataPrefix):], uint32(session.sessionId))
+		if err := c.WriteCustomPacket(payload); err != nil {
+			session.Close()
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// DialSession opens a session on the server with the key with the options passed. Dialing a server that is draining
+// is handled like DialContext does.
+func (l *IpcServer) DialSession(ctx context.Context, key string, opts SessionOptions) (Session, error) {
+	conn, err := l.dialTarget(key)
+	if err != nil {
+		return nil, err
+	}
+	return conn.OpenSessionWithOptions(ctx, opts)
+}
+
+// DialSession opens a session on the server listening on the socket path with the options passed, connecting to it
+// first if needed.
+func (c *IpcClient) DialSession(ctx context.Context, path string, opts SessionOptions) (Session, error) {
+	conn, err := c.GetOrCreateConn(path)
+	if err != nil {
+		return nil, err
+	}
+	return conn.OpenSessionWithOptions(ctx, opts)
+}
@@ -0,0 +1,72 @@
+package gtipc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gameparrot/gtipc/ipcprotocol"
+)
+
+// Names of the metrics reported by gtipc.
+const (
+	// MetricSessions is a gauge of the open sessions per server
+	MetricSessions = "gtipc_sessions"
+	// MetricPacketsSent is a counter of IPC packets sent per server and packet type
+	MetricPacketsSent = "gtipc_ipc_packets_sent_total"
+	// MetricPacketsReceived is a counter of IPC packets received per server and packet type
+	MetricPacketsReceived = "gtipc_ipc_packets_received_total"
+	// MetricBytesSent is a counter of IPC bytes sent per server, including frame headers
+	MetricBytesSent = "gtipc_ipc_bytes_sent_total"
+	// MetricBytesReceived is a counter of IPC bytes received per server, including frame headers
+	MetricBytesReceived = "gtipc_ipc_bytes_received_total"
+	// MetricDecodeErrors is a counter of IPC packets that could not be decoded per server
+	MetricDecodeErrors = "gtipc_ipc_decode_errors_total"
+	// MetricWriteSeconds is a histogram of the time taken to write an IPC packet, including waiting for the write lock
+	MetricWriteSeconds = "gtipc_ipc_write_seconds"
+	// MetricSessionQueueDepth is a gauge of the payloads queued for sessions but not yet read per server
+	MetricSessionQueueDepth = "gtipc_session_queue_depth"
+	// MetricEventQueueDepth is a gauge of the events queued for the event handler
+	MetricEventQueueDepth = "gtipc_event_queue_depth"
+	// MetricEventsDropped is a counter of events dropped because the queue of the event handler was full
+	MetricEventsDropped = "gtipc_events_dropped_total"
+	// MetricDispatchQueueDepth is a gauge of the packets waiting in a dispatch queue per server and queue
+	MetricDispatchQueueDepth = "gtipc_dispatch_queue_depth"
+	// MetricDispatchOverflows is a counter of packets that found a dispatch queue full per server and queue
+	MetricDispatchOverflows = "gtipc_dispatch_overflows_total"
+	// MetricBlockedPackets is a counter of packets dropped by the upstream handler because the sender is blocked
+	MetricBlockedPackets = "gtipc_upstream_blocked_packets_total"
+	// MetricUpstreamBytesSent is a counter of bytes sent through the upstream handler
+	MetricUpstreamBytesSent = "gtipc_upstream_bytes_sent_total"
+	// MetricUpstreamBytesReceived is a counter of bytes received through the upstream handler
+	MetricUpstreamBytesReceived = "gtipc_upstream_bytes_received_total"
+)
+
+// Metrics receives measurements from gtipc. Labels are passed as alternating names and values. Implementations
+// must be safe for concurrent use.
+type Metrics interface {
+	// AddCounter increases the counter with the name and labels by delta
+	AddCounter(name string, delta float64, labels ...string)
+	// AddGauge changes the gauge with the name and labels by delta
+	AddGauge(name string, delta float64, labels ...string)
+	// SetGauge sets the gauge with the name and labels to value
+	SetGauge(name string, value float64, labels ...string)
+	// ObserveHistogram records value in the histogram with the name and labels
+	ObserveHistogram(name string, value float64, labels ...string)
+}
+
+// NopMetrics is a Metrics implementation that discards all measurements.
+type NopMetrics struct{}
+
+func (NopMetrics) AddCounter(string, float64, ...string)       {}
+func (NopMetrics) AddGauge(string, float64, ...string)         {}
+func (NopMetrics) SetGauge(string, float64, ...string)         {}
+func (NopMetrics) ObserveHistogram(string, float64, ...string) {}
+
+// packetNames returns the names of the packets in a pool by their ID, used as metric labels.
+func packetNames(pool map[uint8]func() ipcprotocol.Packet) map[uint8]string {
+	names := make(map[uint8]string, len(pool))
+	for id, f := range pool {
+		names[id] = strings.TrimPrefix(fmt.Sprintf("%T", f()), "*")
+	}
+	return names
+}
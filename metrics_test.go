@@ -0,0 +1,205 @@
+package gtipc_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gameparrot/gtipc"
+	"github.com/gameparrot/gtipc/gtipctest"
+	"github.com/gameparrot/gtipc/ipcprotocol/user2rak"
+)
+
+func TestPrometheusMetricsText(t *testing.T) {
+	m := gtipc.NewPrometheusMetrics(0.1, 1)
+	m.AddCounter("requests_total", 1, "server", "lobby", "type", "OpenSession")
+	m.AddCounter("requests_total", 2, "server", "lobby", "type", "OpenSession")
+	m.AddCounter("requests_total", 1, "server", `a"b\c`+"\n", "type", "Raw")
+	m.AddGauge("sessions", 3, "server", "lobby")
+	m.AddGauge("sessions", -1, "server", "lobby")
+	m.SetGauge("depth", 7)
+	m.ObserveHistogram("write_seconds", 0.05, "server", "lobby")
+	m.ObserveHistogram("write_seconds", 0.5, "server", "lobby")
+	m.ObserveHistogram("write_seconds", 2, "server", "lobby")
+
+	want := `# TYPE depth gauge
+depth 7
+# TYPE requests_total counter
+requests_total{server="a\"b\\c\n",type="Raw"} 1
+requests_total{server="lobby",type="OpenSession"} 3
+# TYPE sessions gauge
+sessions{server="lobby"} 2
+# TYPE write_seconds histogram
+write_seconds_bucket{server="lobby",le="0.1"} 1
+write_seconds_bucket{server="lobby",le="1"} 2
+write_seconds_bucket{server="lobby",le="+Inf"} 3
+write_seconds_sum{server="lobby"} 2.55
+write_seconds_count{server="lobby"} 3
+`
+	var b strings.Builder
+	if err := m.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != want {
+		t.Fatalf("WriteText wrote\n%s\nwant\n%s", b.String(), want)
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("ServeHTTP set content type %q", ct)
+	}
+	if rec.Body.String() != want {
+		t.Fatalf("ServeHTTP wrote\n%s\nwant\n%s", rec.Body.String(), want)
+	}
+}
+
+// metricValue returns the value of the series passed, such as name{label="value"}, in the text written by m.
+func metricValue(t *testing.T, m *gtipc.PrometheusMetrics, series string) string {
+	t.Helper()
+	var b bytes.Buffer
+	if err := m.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	s := bufio.NewScanner(&b)
+	for s.Scan() {
+		if v, ok := strings.CutPrefix(s.Text(), series+" "); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// connectServerMetrics starts an IpcServer reporting to m with a fake PM server connected to it.
+func connectServerMetrics(t *testing.T, m gtipc.Metrics) (*gtipctest.Server, *gtipc.Conn) {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "gtipc.sock")
+	s, err := gtipc.NewIPCServer(sock, &gtipc.IpcOptions{Log: discardLog, Metrics: m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	fake, err := gtipctest.Connect(sock, "lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fake.Close() })
+	for range 1000 {
+		if conn, ok := s.GetConn("lobby"); ok {
+			return fake, conn
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("fake server did not connect")
+	return nil, nil
+}
+
+// syncReadLoop waits until the read loop of conn has handled every packet the fake server wrote before.
+func syncReadLoop(t *testing.T, fake *gtipctest.Server, conn *gtipc.Conn, n int) {
+	t.Helper()
+	pong := []byte(fmt.Sprintf("MCPE;Lobby;712;1.21.20;%d;20;1;", n))
+	if err := fake.SetPong(pong); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); !bytes.Equal(conn.PongData(), pong); {
+		if time.Now().After(deadline) {
+			t.Fatal("read loop did not handle the pong")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSessionMetrics(t *testing.T) {
+	m := gtipc.NewPrometheusMetrics()
+	fake, conn := connectServerMetrics(t, m)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := conn.OpenSession("203.0.113.7:19132")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := c.(gtipc.Session)
+	fs, err := fake.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := metricValue(t, m, `gtipc_sessions{server="lobby"}`); v != "1" {
+		t.Fatalf("sessions gauge is %q with an open session, want 1", v)
+	}
+	for range 3 {
+		if _, err := fs.Write([]byte{0xfe}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	syncReadLoop(t, fake, conn, 0)
+	if v := metricValue(t, m, `gtipc_session_queue_depth{server="lobby"}`); v != "3" {
+		t.Fatalf("session queue depth is %q with 3 payloads queued, want 3", v)
+	}
+	if _, err := session.ReadPacket(); err != nil {
+		t.Fatal(err)
+	}
+	if v := metricValue(t, m, `gtipc_session_queue_depth{server="lobby"}`); v != "2" {
+		t.Fatalf("session queue depth is %q after reading a payload, want 2", v)
+	}
+	if err := session.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for series, want := range map[string]string{
+		`gtipc_sessions{server="lobby"}`:                                                "0",
+		`gtipc_session_queue_depth{server="lobby"}`:                                     "0",
+		`gtipc_ipc_packets_sent_total{server="lobby",type="rak2user.OpenSession"}`:      "1",
+		`gtipc_ipc_packets_sent_total{server="lobby",type="rak2user.CloseSession"}`:     "1",
+		`gtipc_ipc_packets_received_total{server="lobby",type="user2rak.Encapsulated"}`: "3",
+	} {
+		if v := metricValue(t, m, series); v != want {
+			t.Errorf("%s is %q, want %q", series, v, want)
+		}
+	}
+}
+
+// TestSessionQueueDepthClose closes sessions while the server writes payloads to them and they are read, which must
+// leave the session queue depth at zero. Run with -race.
+func TestSessionQueueDepthClose(t *testing.T) {
+	m := gtipc.NewPrometheusMetrics()
+	fake, conn := connectServerMetrics(t, m)
+	for i := range 50 {
+		c, err := conn.OpenSession("203.0.113.7:19132")
+		if err != nil {
+			t.Fatal(err)
+		}
+		session := c.(gtipc.Session)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				_ = fake.WritePacket(&user2rak.Encapsulated{SessionID: session.ID(), UserPayload: []byte{0xfe}})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for {
+				if _, err := session.ReadPacket(); err != nil {
+					return
+				}
+			}
+		}()
+		time.Sleep(time.Duration(i%5) * 100 * time.Microsecond)
+		_ = session.Close()
+		wg.Wait()
+		syncReadLoop(t, fake, conn, i)
+
+		if v := metricValue(t, m, `gtipc_session_queue_depth{server="lobby"}`); v != "0" {
+			t.Fatalf("session queue depth is %q after closing session %d, want 0", v, i)
+		}
+	}
+}
@@ -1,12 +1,64 @@
 package gtipc
 
-import "log/slog"
+import (
+	"log/slog"
+
+	"github.com/gameparrot/gtipc/ipcprotocol"
+	"github.com/gameparrot/gtipc/ipcprotocol/versions"
+)
 
 type IpcOptions struct {
 	// Handler function for custom packets
 	CustomPacketHandler func(b []byte, serverKey string)
 	// Upstream handler for additional features intended for proxy usage
 	Upstream *UpstreamHandler
+	// Rewriter for the pong data of servers, called before it is returned from PingContext or used by a status provider
+	PongRewriter PongRewriter
+	// Handler for server and session lifecycle events
+	EventHandler EventHandler
+	// Metrics that measurements are reported to
+	Metrics Metrics
+	// Tracer that a span is started with for every dialed session
+	Tracer Tracer
+	// Observer that is called with every IPC frame sent or received
+	FrameObserver FrameObserver
+	// Protocol layout used with PM servers that do not select one in the handshake. Stock PM servers never select
+	// one, as doing so is an extension of gtipc, so this must match their layout. IpcClients always use it.
+	// versions.V1 is used by default
+	Protocol *ipcprotocol.Protocol
+	// Whether PM servers are sent a custom packet encoded with EncodeDrainNotice when they start draining
+	NotifyDrain bool
+	// Function called when a PM server has finished draining
+	DrainComplete DrainCompleteFunc
+	// Function returning the PM server to open sessions on instead of a server that is draining. Dialing a server
+	// that is draining fails with ErrDraining if not set
+	DrainRedirect DrainRedirectFunc
+	// Queue that custom packets are handed to from the read loop of a Conn
+	CustomPacketQueue DispatchQueue
+	// Queue that block and unblock requests are handed to from the read loop of a Conn
+	ControlQueue DispatchQueue
 	// Logger
 	Log *slog.Logger
 }
+
+// withDefaults returns a copy of the options with the logger, metrics, tracer and protocol set to their defaults if
+// they are not set. o may be nil.
+func (o *IpcOptions) withDefaults() *IpcOptions {
+	var opts IpcOptions
+	if o != nil {
+		opts = *o
+	}
+	if opts.Log == nil {
+		opts.Log = slog.Default()
+	}
+	if opts.Metrics == nil {
+		opts.Metrics = NopMetrics{}
+	}
+	if opts.Tracer == nil {
+		opts.Tracer = NopTracer{}
+	}
+	if opts.Protocol == nil {
+		opts.Protocol = versions.V1
+	}
+	return &opts
+}
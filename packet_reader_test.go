@@ -0,0 +1,108 @@
+package gtipc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// chunkReader returns the data it holds in reads of the sizes passed, cycling through them.
+type chunkReader struct {
+	data  []byte
+	sizes []byte
+	n     int
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	size := len(r.data)
+	if len(r.sizes) > 0 {
+		size = max(int(r.sizes[r.n%len(r.sizes)]), 1)
+		r.n++
+	}
+	n := copy(b, r.data[:min(size, len(r.data))])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// appendFrame appends a frame to b, prefixed with its length.
+func appendFrame(b, frame []byte) []byte {
+	return append(binary.BigEndian.AppendUint32(b, uint32(len(frame))), frame...)
+}
+
+// splitFrames returns the complete length prefixed frames at the start of a stream.
+func splitFrames(stream []byte) [][]byte {
+	var frames [][]byte
+	for len(stream) >= 4 {
+		l := binary.BigEndian.Uint32(stream)
+		if uint64(len(stream)-4) < uint64(l) {
+			break
+		}
+		frames = append(frames, stream[4:4+l])
+		stream = stream[4+l:]
+	}
+	return frames
+}
+
+func TestTakePacketsSplitReads(t *testing.T) {
+	var stream []byte
+	for _, frame := range [][]byte{{1, 2, 3}, {}, bytes.Repeat([]byte{4}, 300), {5}} {
+		stream = appendFrame(stream, frame)
+	}
+	want := splitFrames(stream)
+	for size := 1; size <= len(stream); size++ {
+		got := readAllFrames(t, newPacketReader(), &chunkReader{data: bytes.Clone(stream), sizes: []byte{byte(size)}})
+		if !equalFrames(got, want) {
+			t.Fatalf("reads of %d bytes: got %d frames %x, want %d frames %x", size, len(got), got, len(want), want)
+		}
+	}
+}
+
+// FuzzTakePackets reads a stream in reads of varying sizes. The frames returned must be the complete frames in the
+// stream, however the stream is split up.
+func FuzzTakePackets(f *testing.F) {
+	f.Add(appendFrame(appendFrame(nil, []byte{1, 0, 0, 0, 1, 0}), []byte{8, 'p', 'o', 'n', 'g'}), []byte{3})
+	f.Add(appendFrame(nil, nil), []byte{1, 2})
+	f.Add([]byte{0, 0, 0, 5, 1, 2}, []byte{})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 1}, []byte{1})
+	f.Fuzz(func(t *testing.T, stream, sizes []byte) {
+		got := readAllFrames(t, newPacketReader(), &chunkReader{data: bytes.Clone(stream), sizes: sizes})
+		if want := splitFrames(stream); !equalFrames(got, want) {
+			t.Fatalf("got %d frames %x, want %d frames %x", len(got), got, len(want), want)
+		}
+	})
+}
+
+// readAllFrames takes packets until the reader returns io.EOF. Frames are copied, as they may point into buffers
+// of the packet reader.
+func readAllFrames(t *testing.T, p *packetReader, r io.Reader) [][]byte {
+	var frames [][]byte
+	for {
+		pks, err := p.takePackets(r)
+		if errors.Is(err, io.EOF) {
+			return frames
+		}
+		if err != nil {
+			t.Fatalf("take packets: %v", err)
+		}
+		for _, pk := range pks {
+			frames = append(frames, bytes.Clone(pk))
+		}
+	}
+}
+
+func equalFrames(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,156 @@
+package gtipc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sandertv/gophertunnel/minecraft"
+)
+
+// PongRewriter is called with the pong data of a server before it is returned from PingContext or used by a status
+// provider. The pong may be modified in place.
+type PongRewriter func(serverKey string, pong *Pong)
+
+// Pong is the MCPE pong data that a PM server sends in SetName. It has the format
+// MCPE;motd;protocol;version;online;max;guid;sub-motd;gamemode;gamemode-numeric;port-v4;port-v6;
+type Pong struct {
+	// Edition is the edition of the server, which is MCPE for Bedrock servers
+	Edition string `json:"edition"`
+	// MOTD is the first line of the server name shown in the server list
+	MOTD string `json:"motd"`
+	// ProtocolVersion is the network protocol version of the server
+	ProtocolVersion int `json:"protocol_version"`
+	// Version is the Minecraft version of the server, such as 1.21.60
+	Version string `json:"version"`
+	// PlayerCount is the amount of players online
+	PlayerCount int `json:"player_count"`
+	// MaxPlayers is the maximum amount of players
+	MaxPlayers int `json:"max_players"`
+	// ServerGUID is the RakNet GUID of the server
+	ServerGUID string `json:"server_guid"`
+	// SubMOTD is the second line of the server name shown in the server list
+	SubMOTD string `json:"sub_motd"`
+	// GameMode is the name of the default game mode, such as Survival
+	GameMode string `json:"game_mode"`
+	// GameModeNumeric is the numeric ID of the default game mode
+	GameModeNumeric int `json:"game_mode_numeric"`
+	// PortV4 and PortV6 are the ports the server listens on for IPv4 and IPv6
+	PortV4 uint16 `json:"port_v4"`
+	PortV6 uint16 `json:"port_v6"`
+	// Extra holds any trailing fields that are not known
+	Extra []string `json:"extra,omitempty"`
+}
+
+var errInvalidPong = errors.New("invalid pong data")
+
+// ParsePong parses MCPE pong data. At least the fields up to and including the server GUID must be present.
+func ParsePong(data []byte) (*Pong, error) {
+	frag := splitPong(string(data))
+	if len(frag) < 7 {
+		return nil, fmt.Errorf("%w: expected at least 7 fields, got %d", errInvalidPong, len(frag))
+	}
+	p := &Pong{Edition: frag[0], MOTD: frag[1], Version: frag[3], ServerGUID: frag[6]}
+	var err error
+	if p.ProtocolVersion, err = strconv.Atoi(frag[2]); err != nil {
+		return nil, fmt.Errorf("%w: protocol version: %w", errInvalidPong, err)
+	}
+	if p.PlayerCount, err = strconv.Atoi(frag[4]); err != nil {
+		return nil, fmt.Errorf("%w: player count: %w", errInvalidPong, err)
+	}
+	if p.MaxPlayers, err = strconv.Atoi(frag[5]); err != nil {
+		return nil, fmt.Errorf("%w: max player count: %w", errInvalidPong, err)
+	}
+	if len(frag) > 7 {
+		p.SubMOTD = frag[7]
+	}
+	if len(frag) > 8 {
+		p.GameMode = frag[8]
+	}
+	if len(frag) > 9 {
+		p.GameModeNumeric, _ = strconv.Atoi(frag[9])
+	}
+	if len(frag) > 10 {
+		port, _ := strconv.ParseUint(frag[10], 10, 16)
+		p.PortV4 = uint16(port)
+	}
+	if len(frag) > 11 {
+		port, _ := strconv.ParseUint(frag[11], 10, 16)
+		p.PortV6 = uint16(port)
+	}
+	if len(frag) > 12 {
+		p.Extra = frag[12:]
+	}
+	return p, nil
+}
+
+// Marshal serialises the pong back into the MCPE pong format. Semicolons and backslashes in fields are escaped with a
+// backslash. Port fields are only written if set.
+func (p *Pong) Marshal() []byte {
+	frag := []string{
+		p.Edition,
+		p.MOTD,
+		strconv.Itoa(p.ProtocolVersion),
+		p.Version,
+		strconv.Itoa(p.PlayerCount),
+		strconv.Itoa(p.MaxPlayers),
+		p.ServerGUID,
+		p.SubMOTD,
+		p.GameMode,
+		strconv.Itoa(p.GameModeNumeric),
+	}
+	if p.PortV4 != 0 || p.PortV6 != 0 || len(p.Extra) > 0 {
+		frag = append(frag, strconv.Itoa(int(p.PortV4)), strconv.Itoa(int(p.PortV6)))
+	}
+	frag = append(frag, p.Extra...)
+
+	var b strings.Builder
+	for _, f := range frag {
+		b.WriteString(pongEscaper.Replace(f))
+		b.WriteByte(';')
+	}
+	return []byte(b.String())
+}
+
+// ServerStatus returns the pong as a gophertunnel server status.
+func (p *Pong) ServerStatus() minecraft.ServerStatus {
+	return minecraft.ServerStatus{ServerName: p.MOTD, ServerSubName: p.SubMOTD, PlayerCount: p.PlayerCount, MaxPlayers: p.MaxPlayers}
+}
+
+// pongEscaper escapes the semicolons and backslashes of a pong field.
+var pongEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`)
+
+// splitPong splits the pong data passed by semicolons. Semicolons and backslashes escaped with a backslash are kept in
+// the field, and any other backslash is kept as is.
+func splitPong(s string) []string {
+	var (
+		frags   []string
+		current strings.Builder
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			if r != ';' && r != '\\' {
+				current.WriteByte('\\')
+			}
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == ';':
+			frags = append(frags, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if escaped {
+		current.WriteByte('\\')
+	}
+	if current.Len() > 0 {
+		frags = append(frags, current.String())
+	}
+	return frags
+}
@@ -0,0 +1,107 @@
+package gtipc_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gameparrot/gtipc"
+)
+
+var pongTests = []struct {
+	name string
+	pong gtipc.Pong
+	data string
+}{
+	{
+		name: "minimal",
+		pong: gtipc.Pong{Edition: "MCPE", MOTD: "Lobby", ProtocolVersion: 712, Version: "1.21.20", PlayerCount: 3, MaxPlayers: 20, ServerGUID: "1"},
+		data: "MCPE;Lobby;712;1.21.20;3;20;1;;;0;",
+	},
+	{
+		name: "full",
+		pong: gtipc.Pong{Edition: "MCPE", MOTD: "Lobby", ProtocolVersion: 712, Version: "1.21.20", PlayerCount: 3, MaxPlayers: 20, ServerGUID: "1", SubMOTD: "gtipc", GameMode: "Survival", GameModeNumeric: 1, PortV4: 19132, PortV6: 19133},
+		data: "MCPE;Lobby;712;1.21.20;3;20;1;gtipc;Survival;1;19132;19133;",
+	},
+	{
+		name: "numeric game mode without name",
+		pong: gtipc.Pong{Edition: "MCPE", MOTD: "Lobby", ProtocolVersion: 712, Version: "1.21.20", MaxPlayers: 20, ServerGUID: "1", GameModeNumeric: 2},
+		data: "MCPE;Lobby;712;1.21.20;0;20;1;;;2;",
+	},
+	{
+		name: "extra fields",
+		pong: gtipc.Pong{Edition: "MCPE", MOTD: "Lobby", ProtocolVersion: 712, Version: "1.21.20", MaxPlayers: 20, ServerGUID: "1", Extra: []string{"a", ""}},
+		data: "MCPE;Lobby;712;1.21.20;0;20;1;;;0;0;0;a;;",
+	},
+	{
+		name: "escaped semicolons and backslashes",
+		pong: gtipc.Pong{Edition: "MCPE", MOTD: `a;b\`, ProtocolVersion: 712, Version: "1.21.20", MaxPlayers: 20, ServerGUID: "1", SubMOTD: `\;\\`},
+		data: `MCPE;a\;b\\;712;1.21.20;0;20;1;\\\;\\\\;;0;`,
+	},
+}
+
+func TestPongMarshal(t *testing.T) {
+	for _, tt := range pongTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if data := string(tt.pong.Marshal()); data != tt.data {
+				t.Fatalf("Marshal returned %q, want %q", data, tt.data)
+			}
+			p, err := gtipc.ParsePong([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*p, tt.pong) {
+				t.Fatalf("ParsePong returned %+v, want %+v", *p, tt.pong)
+			}
+		})
+	}
+}
+
+func TestParsePong(t *testing.T) {
+	for _, tt := range []struct {
+		data string
+		want gtipc.Pong
+		err  bool
+	}{
+		{data: "MCPE;Lobby;712;1.21.20;3;20;1", want: gtipc.Pong{Edition: "MCPE", MOTD: "Lobby", ProtocolVersion: 712, Version: "1.21.20", PlayerCount: 3, MaxPlayers: 20, ServerGUID: "1"}},
+		// A backslash that escapes neither a semicolon nor a backslash is kept, as is one at the end.
+		{data: `MCPE;a\b;712;1.21.20;3;20;1;c\`, want: gtipc.Pong{Edition: "MCPE", MOTD: `a\b`, ProtocolVersion: 712, Version: "1.21.20", PlayerCount: 3, MaxPlayers: 20, ServerGUID: "1", SubMOTD: `c\`}},
+		{data: "MCPE;Lobby;712;1.21.20;3;20", err: true},
+		{data: "MCPE;Lobby;x;1.21.20;3;20;1;", err: true},
+		{data: "MCPE;Lobby;712;1.21.20;x;20;1;", err: true},
+		{data: "MCPE;Lobby;712;1.21.20;3;x;1;", err: true},
+	} {
+		p, err := gtipc.ParsePong([]byte(tt.data))
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParsePong(%q) returned no error", tt.data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePong(%q): %v", tt.data, err)
+		} else if !reflect.DeepEqual(*p, tt.want) {
+			t.Errorf("ParsePong(%q) returned %+v, want %+v", tt.data, *p, tt.want)
+		}
+	}
+}
+
+// FuzzPong checks that a parsed pong is parsed to the same pong again after marshalling it.
+func FuzzPong(f *testing.F) {
+	for _, tt := range pongTests {
+		f.Add(tt.data)
+	}
+	f.Fuzz(func(t *testing.T, data string) {
+		p, err := gtipc.ParsePong([]byte(data))
+		if err != nil {
+			return
+		}
+		b := p.Marshal()
+		q, err := gtipc.ParsePong(b)
+		if err != nil {
+			t.Fatalf("ParsePong(%q) of marshalled pong: %v", b, err)
+		}
+		if !reflect.DeepEqual(p, q) {
+			t.Fatalf("pong %+v marshalled to %q parses to %+v", p, b, q)
+		}
+	})
+}
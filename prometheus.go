@@ -0,0 +1,184 @@
+package gtipc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram buckets used by PrometheusMetrics, in seconds.
+var DefaultBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+// PrometheusMetrics is a Metrics implementation that keeps all measurements in memory and serves them over HTTP in
+// the Prometheus text exposition format.
+type PrometheusMetrics struct {
+	mu       sync.Mutex
+	families map[string]*metricFamily
+	buckets  []float64
+}
+
+type metricFamily struct {
+	kind   string
+	series map[string]*metricSeries
+}
+
+type metricSeries struct {
+	value   float64
+	count   uint64
+	buckets []uint64
+}
+
+// NewPrometheusMetrics returns a new PrometheusMetrics. Histograms use the buckets passed, or DefaultBuckets if
+// none are passed.
+func NewPrometheusMetrics(buckets ...float64) *PrometheusMetrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	return &PrometheusMetrics{families: make(map[string]*metricFamily), buckets: buckets}
+}
+
+// AddCounter ...
+func (p *PrometheusMetrics) AddCounter(name string, delta float64, labels ...string) {
+	p.mu.Lock()
+	p.series(name, "counter", labels).value += delta
+	p.mu.Unlock()
+}
+
+// AddGauge ...
+func (p *PrometheusMetrics) AddGauge(name string, delta float64, labels ...string) {
+	p.mu.Lock()
+	p.series(name, "gauge", labels).value += delta
+	p.mu.Unlock()
+}
+
+// SetGauge ...
+func (p *PrometheusMetrics) SetGauge(name string, value float64, labels ...string) {
+	p.mu.Lock()
+	p.series(name, "gauge", labels).value = value
+	p.mu.Unlock()
+}
+
+// ObserveHistogram ...
+func (p *PrometheusMetrics) ObserveHistogram(name string, value float64, labels ...string) {
+	p.mu.Lock()
+	s := p.series(name, "histogram", labels)
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(p.buckets))
+	}
+	for i, upper := range p.buckets {
+		if value <= upper {
+			s.buckets[i]++
+		}
+	}
+	s.value += value
+	s.count++
+	p.mu.Unlock()
+}
+
+// series returns the series of the family with the name and labels, creating it if it doesn't exist yet. p.mu must
+// be held.
+func (p *PrometheusMetrics) series(name, kind string, labels []string) *metricSeries {
+	f, ok := p.families[name]
+	if !ok {
+		f = &metricFamily{kind: kind, series: make(map[string]*metricSeries)}
+		p.families[name] = f
+	}
+	key := formatLabels(labels)
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{}
+		f.series[key] = s
+	}
+	return s
+}
+
+// ServeHTTP writes all metrics in the Prometheus text exposition format.
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	p.WriteText(w)
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format to wr.
+func (p *PrometheusMetrics) WriteText(wr io.Writer) error {
+	w := bufio.NewWriter(wr)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	names := make([]string, 0, len(p.families))
+	for name := range p.families {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		f := p.families[name]
+		fmt.Fprintf(w, "# TYPE %s %s\n", name, f.kind)
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			if f.kind != "histogram" {
+				fmt.Fprintf(w, "%s%s %s\n", name, wrapLabels(key), formatFloat(s.value))
+				continue
+			}
+			for i, upper := range p.buckets {
+				fmt.Fprintf(w, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(key, `le="`+formatFloat(upper)+`"`)), s.buckets[i])
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, wrapLabels(joinLabels(key, `le="+Inf"`)), s.count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", name, wrapLabels(key), formatFloat(s.value))
+			fmt.Fprintf(w, "%s_count%s %d\n", name, wrapLabels(key), s.count)
+		}
+	}
+	return w.Flush()
+}
+
+// formatLabels formats alternating label names and values as name="value" pairs separated by commas.
+func formatLabels(labels []string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(labels[i+1]))
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func joinLabels(a, b string) string {
+	if a == "" {
+		return b
+	}
+	return a + "," + b
+}
+
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,528 @@
+package gtipc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sandertv/gophertunnel/minecraft"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"github.com/sandertv/gophertunnel/minecraft/protocol/packet"
+)
+
+// networkID is used to register a unique gophertunnel network for every IpcServer that ProxySessions dial through.
+var networkID atomic.Int64
+
+// ProxySession pairs a player connected to the proxy with a session on a PM server, and forwards packets between
+// them. The session can be moved to another PM server with SwitchTo without the player reconnecting. Transfers
+// requested by PM servers with EncodeTransferCommand are performed with SwitchTo as well.
+//
+// The player keeps the entity IDs given by the first server. In packets that refer to entities, they are swapped
+// with the IDs the current server knows the player by, so an entity of that server with the first IDs of the player
+// is not mistaken for it. Entities, chunks, the game mode, time, difficulty and game rules are reset on a switch;
+// other client state such as boss bars, scoreboards and the player list is left to the servers.
+type ProxySession struct {
+	ipc    *IpcServer
+	player *minecraft.Conn
+
+	// switchMu is held for the duration of a switch, so that only one is performed at a time.
+	switchMu sync.Mutex
+
+	mu        sync.Mutex
+	backend   *minecraft.Conn
+	session   *clientConn
+	key       string
+	dimension int32
+	// switching is set while the player is moved to another server. The packets of the player are dropped in the
+	// meantime, except for acks of dimension changes, which are sent to dimensionAck.
+	switching    bool
+	dimensionAck chan struct{}
+
+	// runtimeID and uniqueID are the IDs the player knows itself by, given by the first server. backendRuntimeID
+	// and backendUniqueID are the IDs the current server knows the player by.
+	runtimeID        uint64
+	uniqueID         int64
+	backendRuntimeID uint64
+	backendUniqueID  int64
+
+	entities map[int64]struct{}
+	closed   bool
+
+	once sync.Once
+	done chan struct{}
+}
+
+// NewProxySession returns a new ProxySession for a player that has connected to the proxy. Call Connect to connect
+// the player to its first server.
+func NewProxySession(ipc *IpcServer, player *minecraft.Conn) *ProxySession {
+	return &ProxySession{
+		ipc:          ipc,
+		player:       player,
+		dimensionAck: make(chan struct{}, 1),
+		entities:     make(map[int64]struct{}),
+		done:         make(chan struct{}),
+	}
+}
+
+// Connect opens a session on the PM server with the key, starts the game for the player with the game data of the
+// server and starts forwarding packets. It must be called once, before SwitchTo.
+func (s *ProxySession) Connect(ctx context.Context, key string) error {
+	backend, session, err := s.dial(ctx, key)
+	if err != nil {
+		return err
+	}
+	data := backend.GameData()
+	if err := s.player.StartGame(data); err != nil {
+		backend.Close()
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		backend.Close()
+		return errors.New("proxy session closed")
+	}
+	s.backend, s.session, s.key = backend, session, session.conn.key
+	s.ipc.registerSwitcher(session, s)
+	s.runtimeID, s.uniqueID, s.dimension = data.EntityRuntimeID, data.EntityUniqueID, data.Dimension
+	s.backendRuntimeID, s.backendUniqueID = data.EntityRuntimeID, data.EntityUniqueID
+	s.mu.Unlock()
+
+	go s.forwardFromPlayer()
+	go s.forwardFromBackend(backend)
+	return nil
+}
+
+// SwitchTo moves the player to the PM server with the key. The new session is spawned before the player is moved,
+// so the player stays on the current server if the new one can't be joined. Once spawned, the session on the current
+// server is closed, the entities and chunks of it are cleared on the client and the player is moved through a
+// dimension change. No packets of either server reach the player while this happens. If the client does not finish
+// the dimension change before ctx is done, the player is disconnected.
+func (s *ProxySession) SwitchTo(ctx context.Context, key string) error {
+	_, err := s.switchTo(ctx, key)
+	return err
+}
+
+// switchTo performs SwitchTo and returns the IPC session of the new backend.
+func (s *ProxySession) switchTo(ctx context.Context, key string) (*clientConn, error) {
+	s.switchMu.Lock()
+	defer s.switchMu.Unlock()
+
+	backend, session, err := s.dial(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	data := backend.GameData()
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		backend.Close()
+		return nil, errors.New("proxy session closed")
+	}
+	old, oldSession := s.backend, s.session
+	s.backend, s.session, s.key = backend, session, session.conn.key
+	s.backendRuntimeID, s.backendUniqueID = data.EntityRuntimeID, data.EntityUniqueID
+	s.switching = true
+	entities, dimension := s.entities, s.dimension
+	s.entities = make(map[int64]struct{})
+	s.ipc.unregisterSwitcher(oldSession, s)
+	s.mu.Unlock()
+
+	// The forwarding goroutine of the old backend returns once it sees that the backend has been replaced.
+	old.Close()
+	for id := range entities {
+		_ = s.player.WritePacket(&packet.RemoveActor{EntityUniqueID: id})
+	}
+
+	// The client only drops the chunks of the current world when the dimension changes, so if the new server
+	// spawns the player in the same dimension, the player is first moved through another one.
+	if data.Dimension == dimension {
+		temp := int32(packet.DimensionOverworld)
+		if dimension == packet.DimensionOverworld {
+			temp = packet.DimensionNether
+		}
+		if err := s.changeDimension(ctx, temp, data); err != nil {
+			s.disconnect("Failed to switch server")
+			return nil, err
+		}
+	}
+	if err := s.changeDimension(ctx, data.Dimension, data); err != nil {
+		s.disconnect("Failed to switch server")
+		return nil, err
+	}
+
+	_ = s.player.WritePacket(&packet.SetPlayerGameType{GameType: data.PlayerGameMode})
+	_ = s.player.WritePacket(&packet.SetTime{Time: int32(data.Time)})
+	_ = s.player.WritePacket(&packet.SetDifficulty{Difficulty: uint32(data.Difficulty)})
+	_ = s.player.WritePacket(&packet.GameRulesChanged{GameRules: data.GameRules})
+	_ = s.player.Flush()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errors.New("proxy session closed")
+	}
+	s.dimension = data.Dimension
+	s.switching = false
+	s.ipc.registerSwitcher(session, s)
+	go s.forwardFromBackend(backend)
+	return session, nil
+}
+
+// Key returns the key of the PM server the player is on.
+func (s *ProxySession) Key() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.key
+}
+
+// Player returns the conn of the player.
+func (s *ProxySession) Player() *minecraft.Conn {
+	return s.player
+}
+
+// Backend returns the conn of the session on the PM server the player is on.
+func (s *ProxySession) Backend() *minecraft.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.backend
+}
+
+// Done returns a channel that is closed once the session is closed.
+func (s *ProxySession) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close closes the player and the session on the PM server.
+func (s *ProxySession) Close() error {
+	s.once.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		if s.backend != nil {
+			s.ipc.unregisterSwitcher(s.session, s)
+			s.backend.Close()
+		}
+		s.mu.Unlock()
+		s.player.Close()
+		close(s.done)
+	})
+	return nil
+}
+
+// disconnect disconnects the player with the message passed and closes the session.
+func (s *ProxySession) disconnect(message string) {
+	_ = s.player.WritePacket(&packet.Disconnect{Message: message})
+	s.Close()
+}
+
+// dial opens a session for the player on the PM server with the key and spawns it. The IPC session of the backend is
+// returned along with it, which is on another server if the one with the key is draining and the session was
+// redirected.
+func (s *ProxySession) dial(ctx context.Context, key string) (*minecraft.Conn, *clientConn, error) {
+	// The client ID is used to find the IPC session that gophertunnel opened through the IpcServer. The player's
+	// own server address is dialed, as gophertunnel sends the address dialed to the server in the client data.
+	clientID := rand.Int63()
+	ctx = context.WithValue(ctx, proxyDialKey{}, proxyDial{key: key, clientAddr: s.player.RemoteAddr().String(), clientID: clientID})
+	backend, err := minecraft.Dialer{
+		KeepXBLIdentityData: true,
+		IdentityData:        s.player.IdentityData(),
+		ClientData:          s.player.ClientData(),
+	}.DialContext(ctx, s.ipc.networkName(), s.player.ClientData().ServerAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+	session, ok := s.ipc.sessionByClientID(clientID)
+	if !ok {
+		backend.Close()
+		return nil, nil, errors.New("session closed while connecting")
+	}
+	if err := backend.DoSpawnContext(ctx); err != nil {
+		backend.Close()
+		return nil, nil, err
+	}
+	return backend, session, nil
+}
+
+// changeDimension moves the player to a dimension at the spawn position in the game data and sends empty chunks
+// around it, which the client needs before it finishes the dimension change. It then waits until the client has
+// acknowledged the change, as the client can't change dimensions again before. s.switching must be set.
+func (s *ProxySession) changeDimension(ctx context.Context, dimension int32, data minecraft.GameData) error {
+	select {
+	case <-s.dimensionAck:
+	default:
+	}
+	pos := data.PlayerPosition
+	_ = s.player.WritePacket(&packet.ChangeDimension{Dimension: dimension, Position: pos})
+
+	radius := int32(s.player.ChunkRadius())
+	x, z := int32(math.Floor(float64(pos.X())))>>4, int32(math.Floor(float64(pos.Z())))>>4
+	_ = s.player.WritePacket(&packet.NetworkChunkPublisherUpdate{
+		Position: protocol.BlockPos{int32(pos.X()), int32(pos.Y()), int32(pos.Z())},
+		Radius:   uint32(radius) << 4,
+	})
+	payload := emptyChunkPayload(dimension)
+	for cx := x - radius; cx <= x+radius; cx++ {
+		for cz := z - radius; cz <= z+radius; cz++ {
+			_ = s.player.WritePacket(&packet.LevelChunk{Position: protocol.ChunkPos{cx, cz}, Dimension: dimension, RawPayload: payload})
+		}
+	}
+	_ = s.player.WritePacket(&packet.PlayStatus{Status: packet.PlayStatusPlayerSpawn})
+	if err := s.player.Flush(); err != nil {
+		return err
+	}
+
+	select {
+	case <-s.dimensionAck:
+		return nil
+	case <-s.done:
+		return errors.New("proxy session closed")
+	case <-ctx.Done():
+		return fmt.Errorf("wait for dimension change: %w", ctx.Err())
+	}
+}
+
+// forwardFromPlayer forwards the packets of the player to the current backend until the player disconnects.
+func (s *ProxySession) forwardFromPlayer() {
+	defer s.Close()
+	for {
+		pk, err := s.player.ReadPacket()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		if s.switching {
+			s.mu.Unlock()
+			if pk, ok := pk.(*packet.PlayerAction); ok && pk.ActionType == protocol.PlayerActionDimensionChangeDone {
+				select {
+				case s.dimensionAck <- struct{}{}:
+				default:
+				}
+			}
+			continue
+		}
+		translateEntityIDs(pk, s.runtimeID, s.backendRuntimeID, s.uniqueID, s.backendUniqueID)
+		err = s.backend.WritePacket(pk)
+		s.mu.Unlock()
+		if err != nil {
+			s.ipc.opts.Log.Debug("Failed to forward packet to server", "err", err.Error())
+		}
+	}
+}
+
+// forwardFromBackend forwards the packets of a backend to the player until the backend is replaced or closed.
+// The player is disconnected if the backend closes while the player is on it.
+func (s *ProxySession) forwardFromBackend(backend *minecraft.Conn) {
+	for {
+		pk, err := backend.ReadPacket()
+
+		s.mu.Lock()
+		if s.backend != backend || s.closed {
+			s.mu.Unlock()
+			return
+		}
+		if err != nil {
+			key := s.key
+			s.mu.Unlock()
+			s.ipc.opts.Log.Debug("Lost connection to server", "key", key, "err", err.Error())
+			s.disconnect("Lost connection to server")
+			return
+		}
+		translateEntityIDs(pk, s.backendRuntimeID, s.runtimeID, s.backendUniqueID, s.uniqueID)
+		s.trackEntity(pk)
+		err = s.player.WritePacket(pk)
+		s.mu.Unlock()
+		if err != nil {
+			s.Close()
+			return
+		}
+	}
+}
+
+// trackEntity keeps track of the entities spawned on the client by the current backend, so that they can be
+// removed when switching, and of the dimension the backend moved the player to. It is passed packets after their IDs
+// have been translated. s.mu must be held.
+func (s *ProxySession) trackEntity(pk packet.Packet) {
+	switch pk := pk.(type) {
+	case *packet.ChangeDimension:
+		s.dimension = pk.Dimension
+	case *packet.AddActor:
+		s.entities[pk.EntityUniqueID] = struct{}{}
+	case *packet.AddPlayer:
+		s.entities[pk.AbilityData.EntityUniqueID] = struct{}{}
+	case *packet.AddItemActor:
+		s.entities[pk.EntityUniqueID] = struct{}{}
+	case *packet.AddPainting:
+		s.entities[pk.EntityUniqueID] = struct{}{}
+	case *packet.RemoveActor:
+		delete(s.entities, pk.EntityUniqueID)
+	}
+}
+
+// translateEntityIDs swaps the runtime and unique ID one side knows the player by with the IDs the other side knows
+// it by, in packets that refer to entities. Swapping rather than only replacing the IDs of the player keeps an entity
+// that has the IDs of the player on the other side from being mistaken for it.
+func translateEntityIDs(pk packet.Packet, fromRuntime, toRuntime uint64, fromUnique, toUnique int64) {
+	if fromRuntime == toRuntime && fromUnique == toUnique {
+		return
+	}
+	rid := func(id *uint64) { *id = swapID(*id, fromRuntime, toRuntime) }
+	uid := func(id *int64) { *id = swapID(*id, fromUnique, toUnique) }
+	switch pk := pk.(type) {
+	case *packet.AddActor:
+		rid(&pk.EntityRuntimeID)
+		uid(&pk.EntityUniqueID)
+		for i := range pk.EntityLinks {
+			uid(&pk.EntityLinks[i].RiddenEntityUniqueID)
+			uid(&pk.EntityLinks[i].RiderEntityUniqueID)
+		}
+	case *packet.AddPlayer:
+		rid(&pk.EntityRuntimeID)
+		uid(&pk.AbilityData.EntityUniqueID)
+		for i := range pk.EntityLinks {
+			uid(&pk.EntityLinks[i].RiddenEntityUniqueID)
+			uid(&pk.EntityLinks[i].RiderEntityUniqueID)
+		}
+	case *packet.AddItemActor:
+		rid(&pk.EntityRuntimeID)
+		uid(&pk.EntityUniqueID)
+	case *packet.AddPainting:
+		rid(&pk.EntityRuntimeID)
+		uid(&pk.EntityUniqueID)
+	case *packet.RemoveActor:
+		uid(&pk.EntityUniqueID)
+	case *packet.SetActorLink:
+		uid(&pk.EntityLink.RiddenEntityUniqueID)
+		uid(&pk.EntityLink.RiderEntityUniqueID)
+	case *packet.TakeItemActor:
+		rid(&pk.ItemEntityRuntimeID)
+		rid(&pk.TakerEntityRuntimeID)
+	case *packet.MoveActorAbsolute:
+		rid(&pk.EntityRuntimeID)
+	case *packet.MoveActorDelta:
+		rid(&pk.EntityRuntimeID)
+	case *packet.MovePlayer:
+		rid(&pk.EntityRuntimeID)
+	case *packet.SetActorData:
+		rid(&pk.EntityRuntimeID)
+	case *packet.SetActorMotion:
+		rid(&pk.EntityRuntimeID)
+	case *packet.UpdateAttributes:
+		rid(&pk.EntityRuntimeID)
+	case *packet.MobEffect:
+		rid(&pk.EntityRuntimeID)
+	case *packet.ActorEvent:
+		rid(&pk.EntityRuntimeID)
+	case *packet.Animate:
+		rid(&pk.EntityRuntimeID)
+	case *packet.MobEquipment:
+		rid(&pk.EntityRuntimeID)
+	case *packet.MobArmourEquipment:
+		rid(&pk.EntityRuntimeID)
+	case *packet.PlayerAction:
+		rid(&pk.EntityRuntimeID)
+	case *packet.Respawn:
+		rid(&pk.EntityRuntimeID)
+	case *packet.Interact:
+		rid(&pk.TargetEntityRuntimeID)
+	case *packet.UpdateAbilities:
+		uid(&pk.AbilityData.EntityUniqueID)
+	}
+}
+
+// swapID returns b if id is a, a if id is b and id otherwise.
+func swapID[T comparable](id, a, b T) T {
+	switch id {
+	case a:
+		return b
+	case b:
+		return a
+	}
+	return id
+}
+
+// emptyChunkPayload returns the payload of a chunk without sub chunks in a dimension, holding only the biomes of
+// every sub chunk in the dimension's height range and no border blocks.
+func emptyChunkPayload(dimension int32) []byte {
+	sections := 24
+	switch dimension {
+	case packet.DimensionNether:
+		sections = 8
+	case packet.DimensionEnd:
+		sections = 16
+	}
+	b := make([]byte, 0, sections*2+1)
+	for range sections {
+		// A single-value biome palette in the network format, holding biome 0.
+		b = append(b, 1, 0)
+	}
+	return append(b, 0)
+}
+
+// proxyDialKey is the context key of the proxyDial that a ProxySession dials through its proxyNetwork.
+type proxyDialKey struct{}
+
+// proxyDial is the session a ProxySession opens for its player on a PM server.
+type proxyDial struct {
+	key        string
+	clientAddr string
+	clientID   int64
+}
+
+// proxyNetwork is the gophertunnel network that ProxySessions dial PM servers through. Unlike the IpcServer itself,
+// it takes the session to open from the context, so that the address dialed can be the server address the player
+// joined the proxy with.
+type proxyNetwork struct {
+	l *IpcServer
+}
+
+func (n proxyNetwork) DialContext(ctx context.Context, _ string) (net.Conn, error) {
+	d, ok := ctx.Value(proxyDialKey{}).(proxyDial)
+	if !ok {
+		return nil, errors.New("no session to dial in context")
+	}
+	conn, err := n.l.dialTarget(d.key)
+	if err != nil {
+		return nil, err
+	}
+	return conn.OpenSessionWithClientID(ctx, d.clientAddr, d.clientID)
+}
+
+func (n proxyNetwork) PingContext(ctx context.Context, _ string) ([]byte, error) {
+	d, ok := ctx.Value(proxyDialKey{}).(proxyDial)
+	if !ok {
+		return nil, errors.New("no session to dial in context")
+	}
+	return n.l.PingContext(ctx, d.key)
+}
+
+func (proxyNetwork) Listen(string) (minecraft.NetworkListener, error) {
+	return nil, errors.New("not supported")
+}
+
+func (n proxyNetwork) Compression(conn net.Conn) packet.Compression {
+	return n.l.Compression(conn)
+}
+
+// networkName returns the name of the proxyNetwork of the server, registering it the first time it is called.
+func (l *IpcServer) networkName() string {
+	l.networkOnce.Do(func() {
+		l.network = "gtipc-" + strconv.FormatInt(networkID.Add(1), 10)
+		minecraft.RegisterNetwork(l.network, func(*slog.Logger) minecraft.Network {
+			return proxyNetwork{l: l}
+		})
+	})
+	return l.network
+}
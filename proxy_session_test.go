@@ -0,0 +1,296 @@
+package gtipc_test
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gameparrot/gtipc"
+	"github.com/gameparrot/gtipc/gtipctest"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/sandertv/gophertunnel/minecraft"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"github.com/sandertv/gophertunnel/minecraft/protocol/packet"
+)
+
+// backend is a fake PM server that accepts sessions as Bedrock players through gtipctest.Listen.
+type backend struct {
+	conns chan *minecraft.Conn
+}
+
+// startBackend connects a fake PM server with the key to the IpcServer on the socket, which spawns players with
+// the game data passed.
+func startBackend(t *testing.T, sock, key string, data minecraft.GameData) *backend {
+	t.Helper()
+	fake, err := gtipctest.Connect(sock, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fake.Close() })
+	l, err := fake.Listen(minecraft.ListenConfig{AuthenticationDisabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	b := &backend{conns: make(chan *minecraft.Conn, 1)}
+	go func() {
+		conn, err := gtipctest.AcceptGame(l, data)
+		if err != nil {
+			return
+		}
+		b.conns <- conn
+	}()
+	return b
+}
+
+// accept returns the conn of the next player that spawned on the backend.
+func (b *backend) accept(t *testing.T, ctx context.Context) *minecraft.Conn {
+	t.Helper()
+	select {
+	case conn := <-b.conns:
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	case <-ctx.Done():
+		t.Fatal("player did not spawn on the backend")
+		return nil
+	}
+}
+
+// receivedPacket is a packet received by the client with the time it arrived.
+type receivedPacket struct {
+	pk packet.Packet
+	at time.Time
+}
+
+// client is a Bedrock client connected to the proxy, which acknowledges every dimension change after a delay.
+type client struct {
+	conn    *minecraft.Conn
+	packets chan receivedPacket
+
+	mu   sync.Mutex
+	acks []time.Time
+}
+
+// dialClient connects a client to the proxy listener. It returns the conn the listener accepted for the client and
+// a function that returns the client once it has spawned, which it does once the game is started for it.
+func dialClient(t *testing.T, ctx context.Context, l *minecraft.Listener) (*minecraft.Conn, func() *client) {
+	t.Helper()
+	dialed := make(chan *minecraft.Conn, 1)
+	go func() {
+		defer close(dialed)
+		conn, err := minecraft.Dialer{}.DialContext(ctx, "raknet", l.Addr().String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := conn.DoSpawnContext(ctx); err != nil {
+			t.Error(err)
+			conn.Close()
+			return
+		}
+		dialed <- conn
+	}()
+	player, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { player.Close() })
+	return player.(*minecraft.Conn), func() *client {
+		conn := <-dialed
+		if conn == nil {
+			t.FailNow()
+		}
+		t.Cleanup(func() { conn.Close() })
+		c := &client{conn: conn, packets: make(chan receivedPacket, 4096)}
+		go c.run()
+		return c
+	}
+}
+
+// run reads the packets of the client until its conn is closed. Dimension changes are acknowledged 100ms after
+// they arrive, so that packets the proxy sends before waiting for the ack arrive before it.
+func (c *client) run() {
+	received := make(chan receivedPacket, 4096)
+	go func() {
+		defer close(received)
+		for {
+			pk, err := c.conn.ReadPacket()
+			if err != nil {
+				return
+			}
+			received <- receivedPacket{pk: pk, at: time.Now()}
+		}
+	}()
+	for r := range received {
+		if _, ok := r.pk.(*packet.ChangeDimension); ok {
+			time.Sleep(100 * time.Millisecond)
+			c.mu.Lock()
+			c.acks = append(c.acks, time.Now())
+			c.mu.Unlock()
+			_ = c.conn.WritePacket(&packet.PlayerAction{ActionType: protocol.PlayerActionDimensionChangeDone})
+		}
+		c.packets <- r
+	}
+}
+
+// next returns the next packet received by the client of the type of T, skipping others.
+func next[T packet.Packet](t *testing.T, ctx context.Context, c *client) (T, time.Time) {
+	t.Helper()
+	for {
+		select {
+		case r := <-c.packets:
+			if pk, ok := r.pk.(T); ok {
+				return pk, r.at
+			}
+		case <-ctx.Done():
+			var zero T
+			t.Fatalf("client did not receive a %T", zero)
+			return zero, time.Time{}
+		}
+	}
+}
+
+// must fails the test if err is not nil.
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readBackend returns the next packet a backend received from the player of the type of T, skipping others.
+func readBackend[T packet.Packet](t *testing.T, conn *minecraft.Conn) T {
+	t.Helper()
+	for {
+		pk, err := conn.ReadPacket()
+		if err != nil {
+			var zero T
+			t.Fatalf("read %T from backend: %v", zero, err)
+		}
+		if pk, ok := pk.(T); ok {
+			return pk
+		}
+	}
+}
+
+func TestProxySessionSwitch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sock := filepath.Join(t.TempDir(), "gtipc.sock")
+	ipc, err := gtipc.NewIPCServer(sock, &gtipc.IpcOptions{Log: discardLog})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ipc.Close()
+	pos := mgl32.Vec3{0, 64, 0}
+	lobby := startBackend(t, sock, "lobby", minecraft.GameData{EntityRuntimeID: 1, EntityUniqueID: 1, Dimension: packet.DimensionOverworld, PlayerPosition: pos})
+	// The survival server gives the player other IDs, and spawns it in the nether.
+	survival := startBackend(t, sock, "survival", minecraft.GameData{EntityRuntimeID: 9, EntityUniqueID: 9, Dimension: packet.DimensionNether, PlayerPosition: pos})
+	for _, key := range []string{"lobby", "survival"} {
+		for {
+			if _, ok := ipc.GetConn(key); ok {
+				break
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("server %s did not connect", key)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	l, err := minecraft.ListenConfig{AuthenticationDisabled: true}.Listen("raknet", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	player, spawned := dialClient(t, ctx, l)
+	s := gtipc.NewProxySession(ipc, player)
+	defer s.Close()
+	connected := make(chan error, 1)
+	go func() { connected <- s.Connect(ctx, "lobby") }()
+	lobbyConn := lobby.accept(t, ctx)
+	if err := <-connected; err != nil {
+		t.Fatal(err)
+	}
+	c := spawned()
+
+	// The lobby spawns an entity and moves the player to the nether itself.
+	must(t, lobbyConn.WritePacket(&packet.AddActor{EntityUniqueID: 5, EntityRuntimeID: 5, EntityType: "minecraft:pig"}))
+	must(t, lobbyConn.WritePacket(&packet.ChangeDimension{Dimension: packet.DimensionNether, Position: pos}))
+	must(t, lobbyConn.Flush())
+	if pk, _ := next[*packet.ChangeDimension](t, ctx, c); pk.Dimension != packet.DimensionNether {
+		t.Fatalf("client moved to dimension %d, want the nether", pk.Dimension)
+	}
+	if pk := readBackend[*packet.PlayerAction](t, lobbyConn); pk.ActionType != protocol.PlayerActionDimensionChangeDone || pk.EntityRuntimeID != 0 {
+		t.Fatalf("lobby received %+v, want the ack of the dimension change", pk)
+	}
+
+	switched := make(chan error, 1)
+	go func() { switched <- s.SwitchTo(ctx, "survival") }()
+	survivalConn := survival.accept(t, ctx)
+	if err := <-switched; err != nil {
+		t.Fatal(err)
+	}
+	if s.Key() != "survival" {
+		t.Fatalf("player is on %s after switching, want survival", s.Key())
+	}
+	if pk, _ := next[*packet.RemoveActor](t, ctx, c); pk.EntityUniqueID != 5 {
+		t.Fatalf("client removed entity %d, want the entity of the lobby", pk.EntityUniqueID)
+	}
+	// The lobby left the player in the nether, where the survival server spawns it too, so it must be moved
+	// through the overworld. The second dimension change must only be sent after the first was acknowledged.
+	first, _ := next[*packet.ChangeDimension](t, ctx, c)
+	second, at := next[*packet.ChangeDimension](t, ctx, c)
+	if first.Dimension != packet.DimensionOverworld || second.Dimension != packet.DimensionNether {
+		t.Fatalf("client moved to dimensions %d and %d, want the overworld and then the nether", first.Dimension, second.Dimension)
+	}
+	c.mu.Lock()
+	acks := c.acks
+	c.mu.Unlock()
+	if len(acks) < 2 || !at.After(acks[1]) {
+		t.Fatal("second dimension change of the switch arrived before the first was acknowledged")
+	}
+
+	// An entity of the survival server with the IDs the player has on the client must not be mistaken for it, and
+	// packets about the player use the IDs of the client.
+	must(t, survivalConn.WritePacket(&packet.AddActor{EntityUniqueID: 1, EntityRuntimeID: 1, EntityType: "minecraft:cow"}))
+	must(t, survivalConn.WritePacket(&packet.SetActorMotion{EntityRuntimeID: 9, Velocity: mgl32.Vec3{0, 1, 0}}))
+	must(t, survivalConn.Flush())
+	if pk, _ := next[*packet.AddActor](t, ctx, c); pk.EntityRuntimeID != 9 || pk.EntityUniqueID != 9 {
+		t.Fatalf("client received entity with IDs %d and %d, want 9 and 9", pk.EntityRuntimeID, pk.EntityUniqueID)
+	}
+	if pk, _ := next[*packet.SetActorMotion](t, ctx, c); pk.EntityRuntimeID != 1 {
+		t.Fatalf("client received motion of entity %d, want its own ID 1", pk.EntityRuntimeID)
+	}
+	must(t, c.conn.WritePacket(&packet.Animate{EntityRuntimeID: 1, ActionType: packet.AnimateActionSwingArm}))
+	must(t, c.conn.WritePacket(&packet.Interact{TargetEntityRuntimeID: 9, ActionType: packet.InteractActionMouseOverEntity}))
+	must(t, c.conn.Flush())
+	// The acks of the dimension changes of the switch are not forwarded to the survival server.
+	for {
+		pk, err := survivalConn.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pk, ok := pk.(*packet.PlayerAction); ok && pk.ActionType == protocol.PlayerActionDimensionChangeDone {
+			t.Fatal("survival server received an ack of a dimension change of the switch")
+		}
+		if pk, ok := pk.(*packet.Animate); ok {
+			if pk.EntityRuntimeID != 9 {
+				t.Fatalf("survival server received animation of entity %d, want the player ID 9", pk.EntityRuntimeID)
+			}
+			break
+		}
+	}
+	if pk := readBackend[*packet.Interact](t, survivalConn); pk.TargetEntityRuntimeID != 1 {
+		t.Fatalf("survival server received interaction with entity %d, want its cow with ID 1", pk.TargetEntityRuntimeID)
+	}
+
+	// The session on the lobby was closed once the player was moved.
+	conn, _ := ipc.GetConn("lobby")
+	if n := len(conn.Sessions()); n != 0 {
+		t.Fatalf("lobby has %d sessions after the player was moved, want 0", n)
+	}
+}
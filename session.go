@@ -0,0 +1,58 @@
+package gtipc
+
+import (
+	"net"
+	"time"
+)
+
+// Session is a session opened on a PM server. Reads return the payloads sent by the server for the session, and
+// writes send payloads to it.
+type Session interface {
+	net.Conn
+
+	// ID returns the ID of the session on the server
+	ID() int32
+	// ClientAddr returns the address of the client, as passed when the session was opened
+	ClientAddr() string
+	// ClientID returns the client ID sent to the server when the session was opened
+	ClientID() int64
+	// Opened returns the time the session was opened
+	Opened() time.Time
+	// ReadPacket returns the next payload sent by the server for the session
+	ReadPacket() ([]byte, error)
+	// CloseWithReason closes the session and tells the server why it was closed. The reason is one of the
+	// rak2user.DisconnectReason constants
+	CloseWithReason(reason byte) error
+	// Done returns a channel that is closed once the session is closed
+	Done() <-chan struct{}
+}
+
+// ID ...
+func (c *clientConn) ID() int32 {
+	return c.sessionId
+}
+
+// ClientAddr ...
+func (c *clientConn) ClientAddr() string {
+	return c.clientAddr
+}
+
+// ClientID ...
+func (c *clientConn) ClientID() int64 {
+	return c.clientID
+}
+
+// Opened ...
+func (c *clientConn) Opened() time.Time {
+	return c.opened
+}
+
+// CloseWithReason ...
+func (c *clientConn) CloseWithReason(reason byte) error {
+	return c.closeWithReason(reason)
+}
+
+// Done ...
+func (c *clientConn) Done() <-chan struct{} {
+	return c.ctx.Done()
+}
@@ -0,0 +1,163 @@
+package gtipc
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Span names and events recorded by gtipc.
+const (
+	// SpanDial is the span covering a session, from opening it until it is closed
+	SpanDial = "gtipc.dial"
+
+	// EventOpenSessionSent is recorded once OpenSession has been sent to the server
+	EventOpenSessionSent = "open_session.sent"
+	// EventFirstServerPacket is recorded when the server sends its first packet for the session
+	EventFirstServerPacket = "server.first_packet"
+	// EventAckNotification is recorded when the first ack notification is sent to the server. Later ones are only
+	// counted in the acks attribute set when the session is closed, so long sessions don't grow the span without bound
+	EventAckNotification = "ack_notification"
+	// EventSessionClose is recorded when the session is closed
+	EventSessionClose = "session.close"
+)
+
+// Tracer starts spans. It is shaped after the OpenTelemetry tracer, so that an OpenTelemetry tracer can be adapted
+// with a thin wrapper. The parent span, if any, is taken from ctx.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a single traced operation.
+type Span interface {
+	// AddEvent records an event on the span
+	AddEvent(name string, attrs ...Attribute)
+	// SetAttributes sets attributes on the span
+	SetAttributes(attrs ...Attribute)
+	// RecordError records an error on the span
+	RecordError(err error)
+	// End ends the span. Calls after the first have no effect
+	End()
+}
+
+// Attribute is a key-value pair attached to spans and events.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Attr returns an Attribute with the key and value.
+func Attr(key string, value any) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// NopTracer is a Tracer that records nothing.
+type NopTracer struct{}
+
+// Start ...
+func (NopTracer) Start(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) AddEvent(string, ...Attribute) {}
+func (nopSpan) SetAttributes(...Attribute)    {}
+func (nopSpan) RecordError(error)             {}
+func (nopSpan) End()                          {}
+
+// RecordingTracer is a Tracer that keeps all spans in memory. It is intended for tests.
+type RecordingTracer struct {
+	mu    sync.Mutex
+	spans []*RecordedSpan
+}
+
+// RecordedSpan is a span recorded by a RecordingTracer. Its fields must only be read after the span has ended.
+type RecordedSpan struct {
+	Name       string
+	Parent     *RecordedSpan
+	Attributes []Attribute
+	Events     []RecordedEvent
+	Errors     []error
+	StartTime  time.Time
+	EndTime    time.Time
+
+	mu    sync.Mutex
+	ended bool
+}
+
+// RecordedEvent is an event recorded on a RecordedSpan.
+type RecordedEvent struct {
+	Name       string
+	Time       time.Time
+	Attributes []Attribute
+}
+
+type recordedSpanKey struct{}
+
+// NewRecordingTracer returns a new RecordingTracer.
+func NewRecordingTracer() *RecordingTracer {
+	return &RecordingTracer{}
+}
+
+// Start ...
+func (t *RecordingTracer) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	parent, _ := ctx.Value(recordedSpanKey{}).(*RecordedSpan)
+	s := &RecordedSpan{Name: name, Parent: parent, Attributes: slices.Clone(attrs), StartTime: time.Now()}
+	t.mu.Lock()
+	t.spans = append(t.spans, s)
+	t.mu.Unlock()
+	return context.WithValue(ctx, recordedSpanKey{}, s), s
+}
+
+// Spans returns all spans started so far, in the order they were started.
+func (t *RecordingTracer) Spans() []*RecordedSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.spans)
+}
+
+// AddEvent ...
+func (s *RecordedSpan) AddEvent(name string, attrs ...Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.Events = append(s.Events, RecordedEvent{Name: name, Time: time.Now(), Attributes: slices.Clone(attrs)})
+	}
+}
+
+// SetAttributes ...
+func (s *RecordedSpan) SetAttributes(attrs ...Attribute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.Attributes = append(s.Attributes, attrs...)
+	}
+}
+
+// RecordError ...
+func (s *RecordedSpan) RecordError(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.Errors = append(s.Errors, err)
+	}
+}
+
+// End ...
+func (s *RecordedSpan) End() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.ended = true
+		s.EndTime = time.Now()
+	}
+}
+
+// Ended reports if the span has ended.
+func (s *RecordedSpan) Ended() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ended
+}
@@ -0,0 +1,97 @@
+package gtipc_test
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gameparrot/gtipc"
+	"github.com/gameparrot/gtipc/gtipctest"
+	"github.com/gameparrot/gtipc/ipcprotocol/rak2user"
+)
+
+func TestDialSpan(t *testing.T) {
+	tracer := gtipc.NewRecordingTracer()
+	sock := filepath.Join(t.TempDir(), "gtipc.sock")
+	s, err := gtipc.NewIPCServer(sock, &gtipc.IpcOptions{Log: discardLog, Tracer: tracer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	fake, err := gtipctest.Connect(sock, "lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		if _, ok := s.GetConn("lobby"); ok {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatal("fake server did not connect")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The dial span is a child of the span in the context passed to DialContext.
+	ctx, parent := tracer.Start(ctx, "player.join")
+	c, err := s.DialContext(ctx, "lobby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := c.(gtipc.Session)
+	fs, err := fake.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		if err := fs.WriteWithAck([]byte{0xfe}, int32(i)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := session.ReadPacket(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for len(fs.Acks()) < 3 {
+		if ctx.Err() != nil {
+			t.Fatal("acks were not received")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := session.CloseWithReason(rak2user.DisconnectReasonClientDisconnect); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := tracer.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want the parent and the dial span", len(spans))
+	}
+	span := spans[1]
+	if span.Name != gtipc.SpanDial || span.Parent != parent {
+		t.Fatalf("span %q with parent %v, want %q with the span of the context", span.Name, span.Parent, gtipc.SpanDial)
+	}
+	if !span.Ended() {
+		t.Fatal("dial span not ended after the session was closed")
+	}
+	var events []string
+	for _, e := range span.Events {
+		events = append(events, e.Name)
+	}
+	// Only the first of the acks is recorded as an event, the others are counted.
+	want := []string{gtipc.EventOpenSessionSent, gtipc.EventFirstServerPacket, gtipc.EventAckNotification, gtipc.EventSessionClose}
+	if !slices.Equal(events, want) {
+		t.Fatalf("span has events %v, want %v", events, want)
+	}
+	for _, attr := range []gtipc.Attribute{gtipc.Attr("server", "lobby"), gtipc.Attr("session_id", session.ID()), gtipc.Attr("acks", int64(3))} {
+		if !slices.Contains(span.Attributes, attr) {
+			t.Errorf("span attributes %v do not contain %v", span.Attributes, attr)
+		}
+	}
+	if closeEvent := span.Events[3]; !slices.Contains(closeEvent.Attributes, gtipc.Attr("reason", rak2user.DisconnectReasonClientDisconnect)) {
+		t.Errorf("close event has attributes %v, want the close reason", closeEvent.Attributes)
+	}
+}
@@ -0,0 +1,142 @@
+package gtipc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+)
+
+// transferCommandPrefix starts custom packets that ask the IpcServer to transfer a session to another server. It
+// is followed by the session ID as a big endian int32 and the key of the target server.
+var transferCommandPrefix = []byte("gtipc:transfer\x00")
+
+// transferTimeout is the time a session registered with a switcher has to move to the target server of a transfer.
+const transferTimeout = 30 * time.Second
+
+// switcher moves the client of a session to another server itself, such as a ProxySession does. Transfers of
+// sessions registered with registerSwitcher are routed to their switcher. switchTo returns the new session.
+type switcher interface {
+	switchTo(ctx context.Context, key string) (*clientConn, error)
+}
+
+// sessionRef identifies a session by the key of its server and its ID.
+type sessionRef struct {
+	key       string
+	sessionID int32
+}
+
+// TransferRequest is a session transfer performed on request of a PM server.
+type TransferRequest struct {
+	// From is the key of the server the session was on
+	From string
+	// To is the key of the server the session was moved to
+	To string
+	// SessionID is the ID the session had on the old server
+	SessionID int32
+	// ClientAddr is the address of the client, as passed when the session was opened
+	ClientAddr string
+	// Session is the new session on the target server, on which the client has already logged in and spawned. It
+	// has the client address of the old session, but a client ID of its own, as both sessions are open while the
+	// client spawns. Metadata of the old session is not carried over
+	Session net.Conn
+}
+
+// EncodeTransferCommand returns the payload of a custom packet that asks the IpcServer to move a session to the
+// server with the target key. PM plugins send it with the session ID RakLib assigned to the player. Only sessions
+// of a ProxySession can be transferred; commands for other sessions are logged and ignored.
+func EncodeTransferCommand(sessionID int32, target string) []byte {
+	b := bytes.Clone(transferCommandPrefix)
+	b = binary.BigEndian.AppendUint32(b, uint32(sessionID))
+	return append(b, target...)
+}
+
+// DecodeTransferCommand decodes the payload of a custom packet encoded with EncodeTransferCommand. False is
+// returned if the payload is not a transfer command.
+func DecodeTransferCommand(b []byte) (sessionID int32, target string, ok bool) {
+	rest, ok := bytes.CutPrefix(b, transferCommandPrefix)
+	if !ok || len(rest) < 4 {
+		return 0, "", false
+	}
+	return int32(binary.BigEndian.Uint32(rest)), string(rest[4:]), true
+}
+
+// registerSwitcher routes transfers of a session to s until unregisterSwitcher is called.
+func (l *IpcServer) registerSwitcher(session *clientConn, s switcher) {
+	l.switchersMu.Lock()
+	l.switchers[sessionRef{key: session.conn.key, sessionID: session.sessionId}] = s
+	l.switchersMu.Unlock()
+}
+
+// unregisterSwitcher stops routing transfers of a session to s. It does nothing if another switcher has been
+// registered for the session since.
+func (l *IpcServer) unregisterSwitcher(session *clientConn, s switcher) {
+	ref := sessionRef{key: session.conn.key, sessionID: session.sessionId}
+	l.switchersMu.Lock()
+	if l.switchers[ref] == s {
+		delete(l.switchers, ref)
+	}
+	l.switchersMu.Unlock()
+}
+
+// sessionByClientID returns the open session with the client ID passed, on any server.
+func (l *IpcServer) sessionByClientID(clientID int64) (*clientConn, bool) {
+	for _, conn := range l.conns() {
+		conn.sessionsMut.Lock()
+		for _, session := range conn.sessions {
+			if session.clientID == clientID {
+				conn.sessionsMut.Unlock()
+				return session, true
+			}
+		}
+		conn.sessionsMut.Unlock()
+	}
+	return nil, false
+}
+
+// transfer moves the client of a session of the server with the key from to the server with the key to. The session
+// must have a switcher registered, such as a ProxySession, as a new session only becomes usable once the client has
+// logged in on the new server, which the switcher does for it. The transfer is performed by the switcher in the
+// background and the old session is closed once the client has spawned on the new server. The new session is passed
+// to the event handler once the transfer is done. Transfers of sessions without a switcher fail, leaving the client
+// on the old server.
+func (l *IpcServer) transfer(from string, sessionID int32, to string) error {
+	if from == to {
+		return fmt.Errorf("session is already on server %s", to)
+	}
+	source, ok := l.GetConn(from)
+	if !ok {
+		return errNotFound
+	}
+	source.sessionsMut.Lock()
+	old, ok := source.sessions[sessionID]
+	source.sessionsMut.Unlock()
+	if !ok {
+		return fmt.Errorf("session %d not found", sessionID)
+	}
+	if _, ok := l.GetConn(to); !ok {
+		return errNotFound
+	}
+
+	l.switchersMu.Lock()
+	s, ok := l.switchers[sessionRef{key: from, sessionID: sessionID}]
+	l.switchersMu.Unlock()
+	if !ok {
+		return fmt.Errorf("session %d is not switched by a ProxySession", sessionID)
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(l.ctx, transferTimeout)
+		defer cancel()
+		session, err := s.switchTo(ctx, to)
+		if err != nil {
+			l.opts.Log.Error("Failed to transfer session", "from", from, "session", sessionID, "to", to, "err", err.Error())
+			return
+		}
+		l.DispatchEvent(func(h EventHandler) {
+			h.OnTransferRequested(TransferRequest{From: from, To: session.conn.key, SessionID: sessionID, ClientAddr: old.clientAddr, Session: session})
+		})
+	}()
+	return nil
+}
@@ -2,10 +2,13 @@ package gtipc
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"maps"
 	"net"
+	"net/netip"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sandertv/go-raknet"
@@ -13,28 +16,49 @@ import (
 	"github.com/sandertv/gophertunnel/minecraft/protocol/packet"
 )
 
+// UpstreamHandler is an upstream packet listener with ip block and bandwidth monitoring. A single handler may be
+// shared by several listeners, for example an IPv4 and an IPv6 one.
 type UpstreamHandler struct {
-	sentBytes     int64
-	receivedBytes int64
+	sentBytes     atomic.Int64
+	receivedBytes atomic.Int64
 
-	blocks   map[[16]byte]time.Time
+	blocks   map[netip.Addr]time.Time
 	blocksMu sync.Mutex
 
 	lastBlockGcTime time.Time
 
 	parent raknet.UpstreamPacketListener
 
-	stop chan bool
+	metrics atomic.Value
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	conns    map[*handlerConn]struct{}
+	connsMu  sync.Mutex
+	gcCancel context.CancelFunc
 }
 
 // NewUpstreamHandler returns an upstream packet listener with ip block and bandwidth monitoring
 func NewUpstreamHandler(parent raknet.UpstreamPacketListener) *UpstreamHandler {
-	q := &UpstreamHandler{parent: parent, blocks: make(map[[16]byte]time.Time), stop: make(chan bool)}
-	go q.gc()
+	return NewUpstreamHandlerContext(context.Background(), parent)
+}
+
+// NewUpstreamHandlerContext returns an upstream packet listener with ip block and bandwidth monitoring. The
+// handler and all of its listeners are closed once ctx is done.
+func NewUpstreamHandlerContext(ctx context.Context, parent raknet.UpstreamPacketListener) *UpstreamHandler {
+	q := &UpstreamHandler{parent: parent, blocks: make(map[netip.Addr]time.Time), conns: make(map[*handlerConn]struct{})}
+	q.ctx, q.cancel = context.WithCancel(ctx)
+	context.AfterFunc(q.ctx, func() {
+		q.closeConns()
+	})
 	return q
 }
 
 func (q *UpstreamHandler) ListenPacket(network, address string) (conn net.PacketConn, err error) {
+	if q.ctx.Err() != nil {
+		return nil, net.ErrClosed
+	}
 	if q.parent != nil {
 		conn, err = q.parent.ListenPacket(network, address)
 	} else {
@@ -43,10 +67,85 @@ func (q *UpstreamHandler) ListenPacket(network, address string) (conn net.Packet
 	if err != nil {
 		return nil, err
 	}
-	return newHandlerConn(conn, q), nil
+	hc := newHandlerConn(conn, q)
+
+	q.connsMu.Lock()
+	defer q.connsMu.Unlock()
+	if q.ctx.Err() != nil {
+		conn.Close()
+		return nil, net.ErrClosed
+	}
+	if len(q.conns) == 0 {
+		// The first listener starts the block gc, which then runs until the last listener is closed.
+		var gcCtx context.Context
+		gcCtx, q.gcCancel = context.WithCancel(q.ctx)
+		go q.gc(gcCtx)
+	}
+	q.conns[hc] = struct{}{}
+	return hc, nil
+}
+
+// SetMetrics sets the metrics that blocked packets and bandwidth are reported to
+func (q *UpstreamHandler) SetMetrics(m Metrics) {
+	q.metrics.Store(metricsHolder{m})
+}
+
+// metricsHolder wraps Metrics so that different implementations can be stored in an atomic.Value.
+type metricsHolder struct {
+	Metrics
+}
+
+func (q *UpstreamHandler) getMetrics() Metrics {
+	if h, ok := q.metrics.Load().(metricsHolder); ok {
+		return h.Metrics
+	}
+	return NopMetrics{}
+}
+
+// Conns returns the packet conns that are currently open through the handler
+func (q *UpstreamHandler) Conns() []net.PacketConn {
+	q.connsMu.Lock()
+	defer q.connsMu.Unlock()
+	conns := make([]net.PacketConn, 0, len(q.conns))
+	for c := range q.conns {
+		conns = append(conns, c)
+	}
+	return conns
 }
 
-func (q *UpstreamHandler) gc() {
+// Close closes the handler and every packet conn opened through it. Close may be called multiple times.
+func (q *UpstreamHandler) Close() error {
+	q.cancel()
+	return q.closeConns()
+}
+
+// Done returns a channel that is closed once the handler is closed
+func (q *UpstreamHandler) Done() <-chan struct{} {
+	return q.ctx.Done()
+}
+
+func (q *UpstreamHandler) closeConns() error {
+	var errs []error
+	for _, c := range q.Conns() {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// release removes a closed conn from the handler, stopping the block gc if it was the last one.
+func (q *UpstreamHandler) release(c *handlerConn) {
+	q.connsMu.Lock()
+	defer q.connsMu.Unlock()
+	delete(q.conns, c)
+	if len(q.conns) == 0 && q.gcCancel != nil {
+		q.gcCancel()
+		q.gcCancel = nil
+	}
+}
+
+func (q *UpstreamHandler) gc(ctx context.Context) {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
@@ -54,7 +153,7 @@ func (q *UpstreamHandler) gc() {
 		select {
 		case <-ticker.C:
 			q.gcBlocks()
-		case <-q.stop:
+		case <-ctx.Done():
 			return
 		}
 	}
@@ -65,24 +164,28 @@ func (q *UpstreamHandler) gcBlocks() {
 	defer q.blocksMu.Unlock()
 
 	now := time.Now()
-	maps.DeleteFunc(q.blocks, func(ip [16]byte, t time.Time) bool {
+	maps.DeleteFunc(q.blocks, func(ip netip.Addr, t time.Time) bool {
 		return now.After(t)
 	})
 }
 
-func (q *UpstreamHandler) close() {
-	q.stop <- true
-}
-
 func (q *UpstreamHandler) blockAddress(addr net.IP, duration time.Duration) {
+	key, ok := blockKey(addr)
+	if !ok {
+		return
+	}
 	q.blocksMu.Lock()
-	q.blocks[[16]byte(addr.To16())] = time.Now().Add(duration)
+	q.blocks[key] = time.Now().Add(duration)
 	q.blocksMu.Unlock()
 }
 
 func (q *UpstreamHandler) unblockAddress(addr net.IP) {
+	key, ok := blockKey(addr)
+	if !ok {
+		return
+	}
 	q.blocksMu.Lock()
-	delete(q.blocks, [16]byte(addr.To16()))
+	delete(q.blocks, key)
 	q.blocksMu.Unlock()
 }
 
@@ -90,6 +193,9 @@ type handlerConn struct {
 	parent net.PacketConn
 
 	upstream *UpstreamHandler
+
+	once     sync.Once
+	closeErr error
 }
 
 func newHandlerConn(parent net.PacketConn, upstream *UpstreamHandler) *handlerConn {
@@ -98,16 +204,21 @@ func newHandlerConn(parent net.PacketConn, upstream *UpstreamHandler) *handlerCo
 
 func (q *handlerConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
 	n, addr, err = q.parent.ReadFrom(p)
-	q.upstream.receivedBytes += int64(n)
+	q.upstream.receivedBytes.Add(int64(n))
+	q.upstream.getMetrics().AddCounter(MetricUpstreamBytesReceived, float64(n))
 	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		key, ok := blockKey(udpAddr.IP)
+		if !ok {
+			return
+		}
 		q.upstream.blocksMu.Lock()
 		defer q.upstream.blocksMu.Unlock()
-		addrBytes := [16]byte(udpAddr.IP.To16())
-		if unblockTime, ok := q.upstream.blocks[addrBytes]; ok {
+		if unblockTime, ok := q.upstream.blocks[key]; ok {
 			if time.Now().Before(unblockTime) {
+				q.upstream.getMetrics().AddCounter(MetricBlockedPackets, 1)
 				return 0, addr, nil
 			} else {
-				delete(q.upstream.blocks, addrBytes)
+				delete(q.upstream.blocks, key)
 			}
 		}
 	}
@@ -117,13 +228,17 @@ func (q *handlerConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
 
 func (q *handlerConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
 	num, err := q.parent.WriteTo(p, addr)
-	q.upstream.sentBytes += int64(num)
+	q.upstream.sentBytes.Add(int64(num))
+	q.upstream.getMetrics().AddCounter(MetricUpstreamBytesSent, float64(num))
 	return num, err
 }
 
 func (q *handlerConn) Close() error {
-	q.upstream.close()
-	return q.parent.Close()
+	q.once.Do(func() {
+		q.upstream.release(q)
+		q.closeErr = q.parent.Close()
+	})
+	return q.closeErr
 }
 
 func (q *handlerConn) LocalAddr() net.Addr {
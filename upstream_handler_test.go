@@ -0,0 +1,149 @@
+package gtipc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubListener is a raknet.UpstreamPacketListener that returns stubPacketConns.
+type stubListener struct{}
+
+func (stubListener) ListenPacket(string, string) (net.PacketConn, error) {
+	return &stubPacketConn{}, nil
+}
+
+// stubPacketConn is a net.PacketConn that counts how often it was closed.
+type stubPacketConn struct {
+	net.PacketConn
+	closed atomic.Int32
+}
+
+func (c *stubPacketConn) Close() error {
+	c.closed.Add(1)
+	return nil
+}
+
+// gcRunning reports if the block gc of the handler is running.
+func gcRunning(q *UpstreamHandler) bool {
+	q.connsMu.Lock()
+	defer q.connsMu.Unlock()
+	return q.gcCancel != nil
+}
+
+func listen(t *testing.T, q *UpstreamHandler) (net.PacketConn, *stubPacketConn) {
+	t.Helper()
+	conn, err := q.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, conn.(*handlerConn).parent.(*stubPacketConn)
+}
+
+func TestUpstreamHandlerRefCount(t *testing.T) {
+	q := NewUpstreamHandler(stubListener{})
+	defer q.Close()
+	if gcRunning(q) {
+		t.Fatal("gc running before the first listener")
+	}
+
+	a, _ := listen(t, q)
+	if !gcRunning(q) {
+		t.Fatal("gc not started by the first listener")
+	}
+	b, _ := listen(t, q)
+	if n := len(q.Conns()); n != 2 {
+		t.Fatalf("handler has %d conns, want 2", n)
+	}
+
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !gcRunning(q) {
+		t.Fatal("gc stopped while a listener is still open")
+	}
+	if conns := q.Conns(); len(conns) != 1 || conns[0] != b {
+		t.Fatalf("handler has conns %v, want only the second one", conns)
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gcRunning(q) {
+		t.Fatal("gc still running after the last listener was closed")
+	}
+	if n := len(q.Conns()); n != 0 {
+		t.Fatalf("handler has %d conns after closing all of them", n)
+	}
+
+	// A new listener after the last one was closed starts the gc again.
+	c, _ := listen(t, q)
+	if !gcRunning(q) {
+		t.Fatal("gc not restarted by a new listener")
+	}
+	c.Close()
+}
+
+func TestUpstreamHandlerClose(t *testing.T) {
+	q := NewUpstreamHandler(stubListener{})
+	a, parentA := listen(t, q)
+	_, parentB := listen(t, q)
+
+	for range 2 {
+		if err := q.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n, m := parentA.closed.Load(), parentB.closed.Load(); n != 1 || m != 1 {
+		t.Fatalf("parent conns closed %d and %d times, want once each", n, m)
+	}
+	if gcRunning(q) || len(q.Conns()) != 0 {
+		t.Fatal("handler still has conns or a running gc after Close")
+	}
+	select {
+	case <-q.Done():
+	default:
+		t.Fatal("Done not closed after Close")
+	}
+	if _, err := q.ListenPacket("udp", "127.0.0.1:0"); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("ListenPacket after Close returned %v, want net.ErrClosed", err)
+	}
+}
+
+func TestUpstreamHandlerContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := NewUpstreamHandlerContext(ctx, stubListener{})
+	_, parent := listen(t, q)
+	select {
+	case <-q.Done():
+		t.Fatal("Done closed before the context was cancelled")
+	default:
+	}
+
+	cancel()
+	select {
+	case <-q.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done not closed after the context was cancelled")
+	}
+	// The conns are closed by a function run after the context is done.
+	deadline := time.Now().Add(time.Second)
+	for parent.closed.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("conn not closed after the context was cancelled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := parent.closed.Load(); n != 1 {
+		t.Fatalf("parent conn closed %d times, want once", n)
+	}
+}
@@ -22,6 +22,9 @@ type Decoder struct {
 	pr packetReader
 
 	decompress bool
+	// algorithmHeader specifies if compressed batches hold a byte with the compression algorithm after the header,
+	// which is the case for protocol 649 (1.20.60) and later.
+	algorithmHeader bool
 
 	checkPacketLimit bool
 
@@ -52,6 +55,12 @@ func (decoder *Decoder) EnableCompression() {
 	decoder.decompress = true
 }
 
+// EnableAlgorithmHeader makes the Decoder read the compression algorithm from the byte that follows the batch
+// header, instead of always using flate. It has no effect unless compression is enabled.
+func (decoder *Decoder) EnableAlgorithmHeader() {
+	decoder.algorithmHeader = true
+}
+
 // DisableBatchPacketLimit disables the check that limits the number of packets allowed in a single packet
 // batch. This should typically be called for Decoders decoding from a server connection.
 func (decoder *Decoder) DisableBatchPacketLimit() {
@@ -64,6 +73,12 @@ const (
 	// maximumInBatch is the maximum amount of packets that may be found in a batch. If a compressed batch has
 	// more than this amount, decoding will fail.
 	maximumInBatch = 812
+
+	// compressionAlgorithmFlate, compressionAlgorithmSnappy and compressionAlgorithmNone are the values of the
+	// compression algorithm byte that follows the header of compressed batches.
+	compressionAlgorithmFlate  = 0x00
+	compressionAlgorithmSnappy = 0x01
+	compressionAlgorithmNone   = 0xff
 )
 
 // Decode decodes one 'packet' from the io.Reader passed in NewDecoder(), producing a slice of packets that it
@@ -89,9 +104,27 @@ func (decoder *Decoder) Decode() (packets [][]byte, err error) {
 	data = data[1:]
 
 	if decoder.decompress {
-		data, err = packet.FlateCompression.Decompress(data)
-		if err != nil {
-			return nil, fmt.Errorf("decompress batch: %w", err)
+		var compression packet.Compression = packet.FlateCompression
+		if decoder.algorithmHeader {
+			if len(data) == 0 {
+				return nil, fmt.Errorf("decode batch: missing compression algorithm")
+			}
+			switch data[0] {
+			case compressionAlgorithmFlate:
+			case compressionAlgorithmSnappy:
+				compression = packet.SnappyCompression
+			case compressionAlgorithmNone:
+				compression = nil
+			default:
+				return nil, fmt.Errorf("decode batch: unknown compression algorithm %x", data[0])
+			}
+			data = data[1:]
+		}
+		if compression != nil {
+			data, err = compression.Decompress(data)
+			if err != nil {
+				return nil, fmt.Errorf("decompress batch: %w", err)
+			}
 		}
 	}
 